@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// activatedListeners returns the sockets systemd passed in with socket
+// activation: the first TCP one to serve notes on and the first unix one
+// for qreph add. Either is nil when it did not pass one.
+func activatedListeners() (notes, control net.Listener, err error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if pid != os.Getpid() || fds < 1 {
+		return nil, nil, nil
+	}
+	// Not for the commands of --on-download.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	// The first passed descriptor is always 3.
+	for fd := 3; fd < 3+fds; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("socket %d: %v", fd, err)
+		}
+		switch {
+		case l.Addr().Network() == "tcp" && notes == nil:
+			notes = l
+		case l.Addr().Network() == "unix" && control == nil:
+			control = l
+		default:
+			l.Close()
+		}
+	}
+	return notes, control, nil
+}
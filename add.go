@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// add hands a note to a running qreph daemon and shows its URL.
+func add(args []string) {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	filePath := fs.String("f", "", "add the file at `path` instead of text")
+	count := fs.Int("count", 1, "allow the note to be fetched `n` times before it burns")
+	keep := fs.Bool("keep", false, "serve the note until the daemon stops or --ttl expires instead of once")
+	ttl := fs.Duration("ttl", 0, "burn the note if nobody fetches it within `duration` (default the daemon's --ttl)")
+	socket := fs.String("socket", controlSocket(), "talk to the daemon on the unix socket `path`")
+	qrOpts := addQRFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph add [--count n | --keep] [--ttl duration] [-f path] [text]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if *count < 1 {
+		log.Fatal("--count must be at least 1")
+	}
+
+	var n *share.Note
+	if *filePath != "" {
+		var err error
+		n, err = share.ReadFile(*filePath)
+		if err != nil {
+			log.Fatalf("failed to read file: %v", err)
+		}
+	} else {
+		var content []byte
+		if stdinPiped() {
+			var err error
+			content, err = io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatalf("failed to read from stdin: %v", err)
+			}
+		} else {
+			if fs.NArg() < 1 {
+				fs.Usage()
+				return
+			}
+			content = []byte(strings.Join(fs.Args(), " "))
+		}
+		n = &share.Note{Content: content, ContentType: share.DetectContentType(content)}
+	}
+	if len(n.Content) == 0 {
+		log.Fatal("no content provided")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://qreph/notes", bytes.NewReader(n.Content))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", n.ContentType)
+	if n.Filename != "" {
+		req.Header.Set("X-Qreph-Filename", n.Filename)
+	}
+	req.Header.Set("X-Qreph-Count", strconv.Itoa(*count))
+	if *keep {
+		req.Header.Set("X-Qreph-Keep", "1")
+	}
+	if *ttl > 0 {
+		req.Header.Set("X-Qreph-TTL", strconv.Itoa(int(ttl.Seconds())))
+	}
+	resp, err := controlClient(*socket).Do(req)
+	if err != nil {
+		log.Fatalf("failed to reach the daemon, is qreph daemon running? %v", err)
+	}
+	var created daemonNoteInfo
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || err != nil {
+		log.Fatalf("failed to add the note: %s", resp.Status)
+	}
+
+	if len(created.URLs) > 1 {
+		for i, u := range created.URLs {
+			qrOpts.show(fmt.Sprintf("Serving note %d at (%d of %d):", created.ID, i+1, len(created.URLs)), u, strconv.Itoa(i+1))
+		}
+		return
+	}
+	qrOpts.show(fmt.Sprintf("Serving note %d at:", created.ID), created.URL, "")
+}
+
+// controlClient talks HTTP to the daemon on the unix socket path.
+func controlClient(path string) *http.Client {
+	return &http.Client{
+		Timeout: time.Minute,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
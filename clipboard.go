@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// The clipboard is reached through the platform's command line tools, the
+// same ones a shell user would pipe into. PowerShell is told to use UTF-8 on
+// its pipes, since it would otherwise go through the console code page and
+// mangle anything outside it.
+
+func clipboardReaders() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbpaste"}}
+	case "windows":
+		return [][]string{{"powershell", "-NoProfile", "-Command", "[Console]::OutputEncoding = [Text.Encoding]::UTF8; Get-Clipboard -Raw"}}
+	}
+	var cmds [][]string
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		cmds = append(cmds, []string{"wl-paste", "--no-newline"})
+	}
+	return append(cmds, []string{"xclip", "-selection", "clipboard", "-o"}, []string{"xsel", "--clipboard", "--output"})
+}
+
+func readClipboard() ([]byte, error) {
+	for _, c := range clipboardReaders() {
+		path, err := exec.LookPath(c[0])
+		if err != nil {
+			continue
+		}
+		return exec.Command(path, c[1:]...).Output()
+	}
+	return nil, errors.New("no clipboard tool found (install wl-clipboard, xclip or xsel)")
+}
+
+func clipboardWriters() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"powershell", "-NoProfile", "-Command", "[Console]::InputEncoding = [Text.Encoding]::UTF8; Set-Clipboard -Value ([Console]::In.ReadToEnd())"}}
+	}
+	var cmds [][]string
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		cmds = append(cmds, []string{"wl-copy"})
+	}
+	return append(cmds, []string{"xclip", "-selection", "clipboard", "-i"}, []string{"xsel", "--clipboard", "--input"})
+}
+
+func writeClipboard(data []byte) error {
+	for _, c := range clipboardWriters() {
+		path, err := exec.LookPath(c[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, c[1:]...)
+		cmd.Stdin = bytes.NewReader(data)
+		return cmd.Run()
+	}
+	return errors.New("no clipboard tool found (install wl-clipboard, xclip or xsel)")
+}
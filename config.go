@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// configPath returns where the config file lives, usually
+// $XDG_CONFIG_HOME/qreph/config.toml.
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "qreph", "config.toml"), nil
+}
+
+// parseFlags parses args into flags on top of the defaults from, in
+// increasing precedence, the config file, the selected profile in it and
+// the environment. Top level keys of the file and of a profile apply to
+// every command that has a flag of that name, a [command] table only to
+// that command. Each source replaces the ones below it, so a repeatable
+// flag given on the command line drops what the config file, the profile
+// and the environment set for it.
+func parseFlags(flags *flag.FlagSet, args []string) {
+	flags.String("profile", "", "use the settings of the config file profile `name`")
+	var config map[string]any
+	path, err := configPath()
+	if err == nil {
+		if _, err := toml.DecodeFile(path, &config); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Fatalf("failed to read config: %v", err)
+		}
+	}
+	given := givenFlags(flags, args)
+
+	defaults := map[string]flagDefault{}
+	collectDefaults(defaults, flags, path, "", config)
+	env := map[string]flagDefault{}
+	flags.VisitAll(func(f *flag.Flag) {
+		// Single letter flags like -f and -o name per invocation paths.
+		if len(f.Name) < 2 {
+			return
+		}
+		name := envName(f.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			env[f.Name] = flagDefault{name, value}
+		}
+	})
+	profile, ok := given["profile"]
+	if !ok {
+		if d, ok := env["profile"]; ok {
+			profile = fmt.Sprint(d.value)
+		} else if d, ok := defaults["profile"]; ok {
+			profile = fmt.Sprint(d.value)
+		}
+	}
+	if profile != "" {
+		profiles, _ := config["profiles"].(map[string]any)
+		table, ok := profiles[profile].(map[string]any)
+		if !ok {
+			log.Fatalf("no profile %q in %s", profile, path)
+		}
+		collectDefaults(defaults, flags, path, "profiles."+profile+".", table)
+	}
+	maps.Copy(defaults, env)
+
+	for _, name := range slices.Sorted(maps.Keys(defaults)) {
+		if _, ok := given[name]; !ok {
+			setDefault(flags, defaults[name].source, name, defaults[name].value)
+		}
+	}
+	flags.Parse(args)
+}
+
+// flagDefault is a value for a flag from outside the command line. source
+// names where it came from in errors.
+type flagDefault struct {
+	source string
+	value  any
+}
+
+// collectDefaults adds the top level keys of table that are flags and then
+// its table for the command to defaults, replacing what was there. prefix
+// is the name of table in errors.
+func collectDefaults(defaults map[string]flagDefault, flags *flag.FlagSet, path, prefix string, table map[string]any) {
+	for key, value := range table {
+		if _, ok := value.(map[string]any); ok {
+			continue
+		}
+		if flags.Lookup(key) != nil {
+			defaults[key] = flagDefault{path, value}
+		}
+	}
+	section, _ := table[flags.Name()].(map[string]any)
+	for key, value := range section {
+		if flags.Lookup(key) == nil {
+			log.Fatalf("%s: [%s%s] %s: qreph %s has no such flag", path, prefix, flags.Name(), key, flags.Name())
+		}
+		defaults[key] = flagDefault{path, value}
+	}
+}
+
+// givenFlags returns the flags args sets and the last value given to each,
+// without touching flags. Errors are left for the real parse to report.
+func givenFlags(flags *flag.FlagSet, args []string) map[string]string {
+	given := map[string]string{}
+	scan := flag.NewFlagSet(flags.Name(), flag.ContinueOnError)
+	scan.SetOutput(io.Discard)
+	scan.Usage = func() {}
+	flags.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		scan.Var(&givenValue{given: given, name: f.Name, bool: ok && b.IsBoolFlag()}, f.Name, "")
+	})
+	scan.Parse(args)
+	return given
+}
+
+type givenValue struct {
+	given map[string]string
+	name  string
+	bool  bool
+}
+
+func (v *givenValue) String() string   { return "" }
+func (v *givenValue) IsBoolFlag() bool { return v.bool }
+
+func (v *givenValue) Set(s string) error {
+	v.given[v.name] = s
+	return nil
+}
+
+// envName returns the environment variable for a flag, QREPH_QR_GRAPHICS
+// for qr-graphics.
+func envName(flag string) string {
+	return "QREPH_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// setDefault sets the flag without marking it as given on the command line,
+// so Visit only reports those. Arrays set repeatable flags several times.
+// source names where the value came from in errors.
+func setDefault(flags *flag.FlagSet, source, key string, value any) {
+	values, ok := value.([]any)
+	if !ok {
+		values = []any{value}
+	}
+	for _, v := range values {
+		s := fmt.Sprint(v)
+		if err := flags.Lookup(key).Value.Set(s); err != nil {
+			log.Fatalf("%s: invalid value %q for %s: %v", source, s, key, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseFlagsPrecedence(t *testing.T) {
+	const config = `
+allow = ["10.0.0.0/8", "172.16.0.0/12"]
+name = "config"
+verbose = true
+
+[test]
+name = "section"
+
+[profiles.home]
+allow = ["192.168.0.0/16"]
+name = "profile"
+`
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		allow   []string
+		value   string
+		verbose bool
+	}{
+		{"config", nil, nil, []string{"10.0.0.0/8", "172.16.0.0/12"}, "section", true},
+		{"flag replaces config", nil, []string{"--allow", "192.168.1.0/24"}, []string{"192.168.1.0/24"}, "section", true},
+		{"flags add up", nil, []string{"--allow", "a", "--allow", "b", "--name", "flag"}, []string{"a", "b"}, "flag", true},
+		{"env replaces config", map[string]string{"QREPH_ALLOW": "d", "QREPH_NAME": "env"}, nil, []string{"d"}, "env", true},
+		{"flag replaces env", map[string]string{"QREPH_ALLOW": "d"}, []string{"--allow", "c"}, []string{"c"}, "section", true},
+		{"profile", nil, []string{"--profile", "home"}, []string{"192.168.0.0/16"}, "profile", true},
+		{"profile from env", map[string]string{"QREPH_PROFILE": "home"}, nil, []string{"192.168.0.0/16"}, "profile", true},
+		{"env replaces profile", map[string]string{"QREPH_ALLOW": "d"}, []string{"--profile", "home"}, []string{"d"}, "profile", true},
+		{"flag replaces profile", nil, []string{"--profile", "home", "--allow", "c", "--name", "flag"}, []string{"c"}, "flag", true},
+		{"bool flag", nil, []string{"--verbose=false"}, []string{"10.0.0.0/8", "172.16.0.0/12"}, "section", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("XDG_CONFIG_HOME", dir)
+			t.Setenv("HOME", dir)
+			t.Setenv("AppData", dir)
+			for _, name := range []string{"QREPH_ALLOW", "QREPH_NAME", "QREPH_PROFILE", "QREPH_VERBOSE"} {
+				t.Setenv(name, "")
+				os.Unsetenv(name)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			path, err := configPath()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			var allow []string
+			fs.Func("allow", "", func(s string) error {
+				allow = append(allow, s)
+				return nil
+			})
+			name := fs.String("name", "", "")
+			verbose := fs.Bool("verbose", false, "")
+			parseFlags(fs, tt.args)
+			if !slices.Equal(allow, tt.allow) || *name != tt.value || *verbose != tt.verbose {
+				t.Fatalf("got allow %q, name %q, verbose %v, want %q, %q, %v", allow, *name, *verbose, tt.allow, tt.value, tt.verbose)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// notifyStop relays the signals that should stop qreph to c. On Windows Go
+// delivers Ctrl-C and Ctrl-Break as os.Interrupt and closing the console
+// window as SIGTERM.
+func notifyStop(c chan<- os.Signal) {
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+}
+
+// stdinPiped reports whether stdin comes from a file or pipe rather than a
+// terminal.
+func stdinPiped() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice == 0 && !ptyPipe(os.Stdin)
+}
+
+// readPassword is prompt with the terminal's echo turned off. Piped input
+// has no echo to turn off and is read as prompt does.
+func readPassword(label string) ([]byte, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return []byte(prompt(label)), nil
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	p, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return p, err
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+func ptyPipe(f *os.File) bool {
+	return false
+}
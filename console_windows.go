@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows Terminal understands escape sequences out of the box, but the
+// classic console only does once asked to, and prints them as is before.
+func init() {
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		h := windows.Handle(f.Fd())
+		var mode uint32
+		if windows.GetConsoleMode(h, &mode) == nil {
+			windows.SetConsoleMode(h, mode|windows.ENABLE_PROCESSED_OUTPUT|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+		}
+	}
+}
+
+// ptyPipe reports whether f is the named pipe that mintty and other
+// MSYS2 or Cygwin terminals give programs in place of a console, so
+// qreph run there still sees its input as typed.
+func ptyPipe(f *os.File) bool {
+	// FILE_NAME_INFO: a uint32 length in bytes, then the UTF-16 name.
+	var buf [4 + windows.MAX_PATH*2]byte
+	err := windows.GetFileInformationByHandleEx(windows.Handle(f.Fd()), windows.FileNameInfo, &buf[0], uint32(len(buf)))
+	if err != nil {
+		return false
+	}
+	n := *(*uint32)(unsafe.Pointer(&buf[0])) / 2
+	if n > windows.MAX_PATH {
+		return false
+	}
+	name := string(utf16.Decode(unsafe.Slice((*uint16)(unsafe.Pointer(&buf[4])), n)))
+	return (strings.HasPrefix(name, `\msys-`) || strings.HasPrefix(name, `\cygwin-`)) &&
+		strings.Contains(name, "-pty") && strings.HasSuffix(name, "-from-master")
+}
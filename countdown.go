@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// countdown gives up on a note once nobody has fetched it for a while. Each
+// fetch starts the wait over.
+type countdown struct {
+	line    atomic.Pointer[string]
+	expired chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// startCountdown waits for timeout of idleness of store, showing the time
+// left and the fetches so far on a status line on w if w is not nil. Line
+// returns the status line in any case.
+func startCountdown(store *share.Store, timeout time.Duration, w *os.File) *countdown {
+	c := &countdown{expired: make(chan struct{}), stop: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(c.stopped)
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+		deadline := time.Now().Add(timeout)
+		fetches := 0
+		for {
+			if n := store.Fetches(); n != fetches {
+				fetches, deadline = n, time.Now().Add(timeout)
+			}
+			left := time.Until(deadline).Round(time.Second)
+			line := fmt.Sprintf("waiting… %s, %s left", plural(fetches, "fetch", "fetches"), formatClock(left))
+			c.line.Store(&line)
+			if w != nil {
+				fmt.Fprint(w, "\r\x1b[K"+line)
+			}
+			if left <= 0 {
+				close(c.expired)
+				break
+			}
+			select {
+			case <-tick.C:
+			case <-c.stop:
+				if w != nil {
+					fmt.Fprint(w, "\r\x1b[K")
+				}
+				return
+			}
+		}
+		if w != nil {
+			fmt.Fprintln(w)
+		}
+	}()
+	return c
+}
+
+// Line returns the current status line.
+func (c *countdown) Line() string {
+	if line := c.line.Load(); line != nil {
+		return *line
+	}
+	return ""
+}
+
+// Stop ends the countdown and clears its status line.
+func (c *countdown) Stop() {
+	select {
+	case <-c.stopped:
+	default:
+		close(c.stop)
+		<-c.stopped
+	}
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// formatClock shows d as m:ss, or h:mm:ss from an hour on.
+func formatClock(d time.Duration) string {
+	s := int(d.Seconds())
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
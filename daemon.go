@@ -0,0 +1,635 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// The daemon keeps one listener up and serves every note qreph add hands it
+// over the control socket at its own one-time path, so sharing does not
+// start a process and open a port each time.
+
+// daemonMaxNote caps notes added over the control socket.
+const daemonMaxNote = 256 << 20
+
+type daemonNote struct {
+	id         int
+	path       string
+	note       *share.Note
+	maxFetches int // 0 for --keep
+	prior      int // fetches before the daemon restarted
+	added      time.Time
+	expires    time.Time   // zero without a TTL
+	timer      *time.Timer // fires at expires
+	requests   []daemonRequest
+	store      *share.Store
+	handler    http.Handler
+	done       chan struct{}
+	revoked    chan struct{}
+}
+
+type daemonServer struct {
+	srv      *server
+	fragment string
+	ttl      time.Duration // for notes added without one
+	started  time.Time
+	db       *noteDB // with --store
+
+	mu     sync.Mutex
+	nextID int
+	paths  map[string]*daemonNote
+	ids    map[int]*daemonNote
+	stats  daemonStats
+}
+
+// daemonNoteInfo describes a pending note in the admin API.
+type daemonNoteInfo struct {
+	ID          int      `json:"id"`
+	URL         string   `json:"url"`
+	URLs        []string `json:"urls,omitempty"`
+	ContentType string   `json:"content_type"`
+	Filename    string   `json:"filename,omitempty"`
+	Bytes       int      `json:"bytes"`
+	Fetches     int      `json:"fetches"`
+	MaxFetches  int      `json:"max_fetches,omitempty"`
+	AddedAt     string   `json:"added_at"`
+	ExpiresAt   string   `json:"expires_at,omitempty"`
+
+	Requests []daemonRequest `json:"requests,omitempty"`
+}
+
+// daemonRequest is an entry in the access log of a note.
+type daemonRequest struct {
+	Time      string `json:"time"`
+	Peer      string `json:"peer"`
+	Method    string `json:"method"`
+	Status    int    `json:"status"`
+	Bytes     int64  `json:"bytes"`
+	UserAgent string `json:"user_agent"`
+}
+
+// daemonMaxRequests is how much of the access log a note keeps.
+const daemonMaxRequests = 50
+
+type daemonStats struct {
+	StartedAt string `json:"started_at"`
+	Pending   int    `json:"pending"`
+	Added     int    `json:"added"`
+	Fetched   int    `json:"fetched"` // notes used up
+	Expired   int    `json:"expired"`
+	Revoked   int    `json:"revoked"`
+	Fetches   int    `json:"fetches"` // of all notes together
+	BytesSent int64  `json:"bytes_sent"`
+}
+
+// controlSocket returns the default control socket, in $XDG_RUNTIME_DIR if
+// there is one.
+func controlSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "qreph.sock")
+	}
+	if uid := os.Getuid(); uid >= 0 {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("qreph-%d.sock", uid))
+	}
+	// Windows has no uids, but its temporary directory is per user.
+	return filepath.Join(os.TempDir(), "qreph.sock")
+}
+
+func daemon(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	socket := fs.String("socket", controlSocket(), "take notes from qreph add on the unix socket `path`")
+	storePath := fs.String("store", "", "keep pending notes encrypted in the file at `path` so they survive a restart")
+	dashboard := fs.String("dashboard", "", "serve a web dashboard of the pending notes on the loopback `address` (e.g. 127.0.0.1:8088)")
+	linkTTL := fs.Duration("link-ttl", 0, "make each note URL stop working `duration` after it is handed out; GET /notes/{id} hands out a new one")
+	opts := addServeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph daemon [--tls] [--port port] [--socket path] [--store path] [--dashboard address] [--link-ttl duration]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	opts.hardenProcess()
+	if opts.tui {
+		log.Fatal("qreph daemon has no --tui")
+	}
+	// --ttl applies to each note rather than to the daemon.
+	ttl := opts.ttl
+	opts.ttl = 0
+	opts.hostCheck = true
+	opts.linkTTL = *linkTTL
+
+	var db *noteDB
+	if *storePath != "" {
+		passphrase, err := storePassphrase()
+		if err != nil {
+			log.Fatalf("failed to read passphrase: %v", err)
+		}
+		if db, err = openNoteDB(*storePath, passphrase); err != nil {
+			log.Fatalf("failed to open note store: %v", err)
+		}
+		defer db.Close()
+		if opts.tls {
+			if opts.tlsKey, err = db.tlsKey(); err != nil {
+				log.Fatalf("failed to read TLS key from note store: %v", err)
+			}
+		}
+		if *linkTTL > 0 {
+			// Links handed out before a restart keep working.
+			if opts.linkKey, err = db.linkKey(); err != nil {
+				log.Fatalf("failed to read link key from note store: %v", err)
+			}
+		}
+	}
+
+	notes, control, err := activatedListeners()
+	if err != nil {
+		log.Fatalf("failed to use sockets from systemd: %v", err)
+	}
+	opts.listener = notes
+	// A control socket from systemd is systemd's to remove.
+	ownSocket := control == nil
+	if ownSocket {
+		if control, err = listenControl(*socket); err != nil {
+			log.Fatalf("failed to open control socket: %v", err)
+		}
+	}
+
+	d := &daemonServer{
+		srv:     newServer(opts),
+		ttl:     ttl,
+		started: time.Now(),
+		db:      db,
+		paths:   make(map[string]*daemonNote),
+		ids:     make(map[int]*daemonNote),
+	}
+	if cert := d.srv.Cert(); cert != nil {
+		d.fragment = share.SPKIPin(cert)
+		opts.detail("cert_sha256", "Certificate SHA-256:", share.Fingerprint(cert.Certificate[0]))
+	}
+	if db != nil {
+		d.restore()
+	}
+	d.srv.start(d)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /notes", d.create)
+	mux.HandleFunc("GET /notes", d.list)
+	mux.HandleFunc("GET /notes/{id}", d.get)
+	mux.HandleFunc("DELETE /notes/{id}", d.revoke)
+	mux.HandleFunc("POST /notes/{id}/extend", d.extend)
+	mux.HandleFunc("GET /stats", d.getStats)
+	go func() {
+		if err := http.Serve(control, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Fatalf("control socket failed: %v", err)
+		}
+	}()
+
+	_, urls := d.srv.URLs("/")
+	d.logf("daemon listening on %s, add notes with qreph add", urls[0])
+	if *dashboard != "" {
+		if err := d.serveDashboard(*dashboard); err != nil {
+			log.Fatalf("failed to start dashboard: %v", err)
+		}
+	}
+	d.srv.wait(nil)
+	control.Close()
+	if ownSocket {
+		os.Remove(*socket)
+	}
+	d.srv.stop()
+	// The store keeps its own sealed copies.
+	for _, dn := range d.pending() {
+		dn.store.Burn()
+	}
+}
+
+// logf logs what happens to notes, unless --quiet.
+func (d *daemonServer) logf(format string, args ...any) {
+	if d.srv.opts.verbosity >= 0 {
+		log.Printf(format, args...)
+	}
+}
+
+// listenControl listens on the unix socket path, which only the user may
+// connect to. A socket left behind by a daemon that died is replaced.
+func listenControl(path string) (net.Listener, error) {
+	l, err := listenUnix(path)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS == "windows" {
+		// Windows has no mode bits on sockets; the ACL of the directory counts.
+		return l, nil
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (d *daemonServer) Handler(r *http.Request) (http.Handler, string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dn := d.paths[r.URL.Path]; dn != nil {
+		return dn.handler, dn.path
+	}
+	return http.NotFoundHandler(), ""
+}
+
+func (d *daemonServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, _ := d.Handler(r)
+	h.ServeHTTP(w, r)
+}
+
+// create takes a note over the control socket, with the headers of the
+// relay for --count, --keep and --ttl and the filename in X-Qreph-Filename.
+func (d *daemonServer) create(w http.ResponseWriter, r *http.Request) {
+	content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, daemonMaxNote))
+	if err != nil || len(content) == 0 {
+		http.Error(w, "bad or oversized note", http.StatusBadRequest)
+		return
+	}
+	count, _ := strconv.Atoi(r.Header.Get("X-Qreph-Count"))
+	if count < 1 {
+		count = 1
+	}
+	if r.Header.Get("X-Qreph-Keep") == "1" {
+		count = 0
+	}
+	ttl := d.ttl
+	if secs, err := strconv.Atoi(r.Header.Get("X-Qreph-TTL")); err == nil && secs > 0 {
+		ttl = time.Duration(secs) * time.Second
+	}
+
+	n := &share.Note{Content: content, ContentType: r.Header.Get("Content-Type"), Filename: r.Header.Get("X-Qreph-Filename")}
+	if n.ContentType == "" {
+		n.ContentType = share.DetectContentType(content)
+	}
+	path, _ := share.RandomPath()
+	dn := &daemonNote{path: path, note: n, maxFetches: count, added: time.Now()}
+	if ttl > 0 {
+		dn.expires = dn.added.Add(ttl)
+	}
+	d.mu.Lock()
+	d.nextID++
+	dn.id = d.nextID
+	d.mu.Unlock()
+	if err := d.save(dn); err != nil {
+		log.Printf("failed to store note: %v", err)
+		http.Error(w, "failed to store note", http.StatusInternalServerError)
+		return
+	}
+	d.add(dn)
+	writeJSON(w, http.StatusCreated, d.info(dn))
+}
+
+// restore adds the notes from --store, dropping those that expired while
+// the daemon was down.
+func (d *daemonServer) restore() {
+	notes, err := d.db.load()
+	if err != nil {
+		log.Fatalf("failed to read note store: %v", err)
+	}
+	restored := 0
+	for _, sn := range notes {
+		d.mu.Lock()
+		d.nextID = max(d.nextID, sn.ID)
+		d.mu.Unlock()
+		used := sn.MaxFetches > 0 && sn.Fetches >= sn.MaxFetches
+		if used || !sn.Expires.IsZero() && time.Now().After(sn.Expires) {
+			d.db.delete(sn.ID)
+			continue
+		}
+		n := &share.Note{Content: sn.Content, ContentType: sn.ContentType, Filename: sn.Filename}
+		d.add(&daemonNote{
+			id:         sn.ID,
+			path:       sn.Path,
+			note:       n,
+			maxFetches: sn.MaxFetches,
+			prior:      sn.Fetches,
+			added:      sn.Added,
+			expires:    sn.Expires,
+		})
+		restored++
+	}
+	if restored > 0 {
+		d.logf("restored %s from the store", plural(restored, "note", "notes"))
+	}
+}
+
+// save writes dn to --store, if there is one.
+func (d *daemonServer) save(dn *daemonNote) error {
+	if d.db == nil {
+		return nil
+	}
+	fetches := dn.prior
+	if dn.store != nil {
+		fetches += dn.store.Fetches()
+	}
+	d.mu.Lock()
+	expires := dn.expires
+	d.mu.Unlock()
+	return d.db.put(storedNote{
+		ID:          dn.id,
+		Path:        dn.path,
+		Content:     dn.note.Content,
+		ContentType: dn.note.ContentType,
+		Filename:    dn.note.Filename,
+		MaxFetches:  dn.maxFetches,
+		Fetches:     fetches,
+		Added:       dn.added,
+		Expires:     expires,
+	})
+}
+
+// add serves dn, which has its id, path, note and limits set, until it is
+// used up, expires or is revoked.
+func (d *daemonServer) add(dn *daemonNote) {
+	n := dn.note
+	n.View, n.Lang = share.DetectView(n)
+	dn.done, dn.revoked = make(chan struct{}), make(chan struct{})
+	if !dn.expires.IsZero() {
+		dn.timer = time.NewTimer(time.Until(dn.expires))
+	}
+	remaining := dn.maxFetches
+	if remaining > 0 {
+		remaining -= dn.prior
+	}
+	dn.store = share.NewStore(n, share.WithMaxDownloads(remaining), share.WithCompression(), share.WithOnDelivery(func(f share.Delivery) {
+		d.delivered(f)
+		if err := d.save(dn); err != nil {
+			log.Printf("failed to store note %d: %v", dn.id, err)
+		}
+	}))
+	var handler http.Handler = share.NoteHandler(dn.store, dn.done)
+	if cert := d.srv.Cert(); cert != nil {
+		handler = share.PinnedHandler(dn.store, cert, handler, dn.done)
+	} else {
+		handler = share.ConfirmHandler(handler)
+	}
+	dn.handler = d.record(dn, share.BotFilter(nil, handler))
+
+	d.mu.Lock()
+	d.paths[dn.path] = dn
+	d.ids[dn.id] = dn
+	d.stats.Added++
+	d.mu.Unlock()
+	d.logf("note %d added", dn.id)
+
+	go func() {
+		var expire <-chan time.Time
+		if dn.timer != nil {
+			defer dn.timer.Stop()
+			expire = dn.timer.C
+		}
+		var count *int
+		for count == nil {
+			select {
+			case <-dn.done:
+				d.logf("note %d fetched", dn.id)
+				count = &d.stats.Fetched
+			case <-dn.revoked:
+				d.logf("note %d revoked", dn.id)
+				count = &d.stats.Revoked
+			case <-expire:
+				// Otherwise it was just used up or revoked.
+				if dn.store.Burn() {
+					d.logf("note %d expired", dn.id)
+					count = &d.stats.Expired
+				}
+				expire = nil
+			}
+		}
+		// Wipes the content, which is still there after the last fetch.
+		dn.store.Burn()
+		d.mu.Lock()
+		delete(d.paths, dn.path)
+		delete(d.ids, dn.id)
+		*count++
+		d.mu.Unlock()
+		if d.db != nil {
+			if err := d.db.delete(dn.id); err != nil {
+				log.Printf("failed to remove note %d from the store: %v", dn.id, err)
+			}
+		}
+	}()
+}
+
+// delivered counts a fetch of any note for the stats.
+func (d *daemonServer) delivered(f share.Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats.Fetches++
+	d.stats.BytesSent += f.Bytes
+}
+
+// record keeps the access log of dn.
+func (d *daemonServer) record(dn *daemonNote, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		peer, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			peer = r.RemoteAddr
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		dn.requests = append(dn.requests, daemonRequest{
+			Time:      time.Now().UTC().Format(time.RFC3339),
+			Peer:      peer,
+			Method:    r.Method,
+			Status:    sw.status,
+			Bytes:     sw.bytes,
+			UserAgent: r.UserAgent(),
+		})
+		if len(dn.requests) > daemonMaxRequests {
+			dn.requests = dn.requests[len(dn.requests)-daemonMaxRequests:]
+		}
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.status, w.wrote = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// urls returns the URLs of dn. With --link-ttl they are signed afresh each
+// time, so GET /notes and the dashboard hand out links that work for the
+// full --link-ttl.
+func (d *daemonServer) urls(dn *daemonNote) []string {
+	_, urls := d.srv.URLs(d.srv.SignPath(dn.path))
+	if d.fragment != "" {
+		for i := range urls {
+			urls[i] += "#" + d.fragment
+		}
+	}
+	return urls
+}
+
+func (d *daemonServer) info(dn *daemonNote) daemonNoteInfo {
+	d.mu.Lock()
+	expires, requests := dn.expires, slices.Clone(dn.requests)
+	d.mu.Unlock()
+	urls := d.urls(dn)
+	info := daemonNoteInfo{
+		ID:          dn.id,
+		URL:         urls[0],
+		ContentType: dn.note.ContentType,
+		Filename:    dn.note.Filename,
+		Bytes:       len(dn.note.Content),
+		Fetches:     dn.prior + dn.store.Fetches(),
+		MaxFetches:  dn.maxFetches,
+		AddedAt:     dn.added.UTC().Format(time.RFC3339),
+		Requests:    requests,
+	}
+	if len(urls) > 1 {
+		info.URLs = urls
+	}
+	if !expires.IsZero() {
+		info.ExpiresAt = expires.UTC().Format(time.RFC3339)
+	}
+	return info
+}
+
+// pending returns the notes not yet gone, oldest first.
+func (d *daemonServer) pending() []*daemonNote {
+	d.mu.Lock()
+	notes := make([]*daemonNote, 0, len(d.ids))
+	for _, dn := range d.ids {
+		notes = append(notes, dn)
+	}
+	d.mu.Unlock()
+	slices.SortFunc(notes, func(a, b *daemonNote) int { return a.id - b.id })
+	return notes
+}
+
+func (d *daemonServer) list(w http.ResponseWriter, r *http.Request) {
+	notes := d.pending()
+	infos := make([]daemonNoteInfo, len(notes))
+	for i, dn := range notes {
+		infos[i] = d.info(dn)
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (d *daemonServer) lookup(r *http.Request) *daemonNote {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ids[id]
+}
+
+func (d *daemonServer) get(w http.ResponseWriter, r *http.Request) {
+	dn := d.lookup(r)
+	if dn == nil {
+		http.Error(w, "no such note", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, d.info(dn))
+}
+
+func (d *daemonServer) revoke(w http.ResponseWriter, r *http.Request) {
+	dn := d.lookup(r)
+	if dn == nil {
+		http.Error(w, "no such note", http.StatusNotFound)
+		return
+	}
+	dn.revoke()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (dn *daemonNote) revoke() {
+	if dn.store.Burn() {
+		close(dn.revoked)
+	}
+}
+
+// extend pushes the expiry of a note back by the duration in the by query
+// or form value.
+func (d *daemonServer) extend(w http.ResponseWriter, r *http.Request) {
+	dn := d.lookup(r)
+	if dn == nil {
+		http.Error(w, "no such note", http.StatusNotFound)
+		return
+	}
+	by, err := time.ParseDuration(r.FormValue("by"))
+	if err != nil || by <= 0 {
+		http.Error(w, "want a positive duration in by", http.StatusBadRequest)
+		return
+	}
+	if !d.extendNote(dn, by) {
+		http.Error(w, "the note does not expire", http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusOK, d.info(dn))
+}
+
+// extendNote pushes the expiry of dn back by by and reports whether it has
+// one.
+func (d *daemonServer) extendNote(dn *daemonNote, by time.Duration) bool {
+	d.mu.Lock()
+	if dn.timer == nil {
+		d.mu.Unlock()
+		return false
+	}
+	dn.expires = dn.expires.Add(by)
+	dn.timer.Reset(time.Until(dn.expires))
+	d.mu.Unlock()
+	if err := d.save(dn); err != nil {
+		log.Printf("failed to store note %d: %v", dn.id, err)
+	}
+	return true
+}
+
+func (d *daemonServer) getStats(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	stats := d.stats
+	stats.Pending = len(d.ids)
+	d.mu.Unlock()
+	stats.StartedAt = d.started.UTC().Format(time.RFC3339)
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
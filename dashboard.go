@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kevinkokinda/qreph/pkg/qr"
+)
+
+// The dashboard shows the pending notes of the daemon in a browser on the
+// same machine. It only listens on loopback and wants the token from the
+// URL the daemon prints, which it trades for a cookie on the first visit.
+
+var dashboardPage = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="10">
+<title>qreph daemon</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.note { display: flex; gap: 1.5em; border-top: 1px solid #ccc; padding: 1em 0; }
+.note svg { width: 12em; height: 12em; flex: none; }
+.url { font-family: monospace; word-break: break-all; }
+table { border-collapse: collapse; font-size: 0.9em; }
+td, th { text-align: left; padding: 0.1em 0.8em 0.1em 0; }
+form { display: inline; }
+</style>
+</head>
+<body>
+<h1>qreph daemon</h1>
+<p>{{.Stats.Pending}} pending, {{.Stats.Added}} added, {{.Stats.Fetched}} fetched, {{.Stats.Expired}} expired, {{.Stats.Revoked}} revoked since {{.Stats.StartedAt}}.</p>
+{{range .Notes}}
+<div class="note">
+{{.QR}}
+<div>
+<h2>Note {{.ID}}{{with .Filename}}: {{.}}{{end}}</h2>
+<p class="url">{{.URL}}</p>
+<p>{{.ContentType}}, {{.Bytes}} bytes. Fetched {{.Fetches}}{{if .MaxFetches}} of {{.MaxFetches}}{{end}} times.
+Added {{.AddedAt}}.{{with .ExpiresAt}} Expires in <span class="expires" data-at="{{.}}">{{.}}</span>.{{end}}</p>
+<p>
+<form method="post" action="/notes/{{.ID}}/revoke"><button>Revoke</button></form>
+{{if .ExpiresAt}}
+<form method="post" action="/notes/{{.ID}}/extend"><input type="hidden" name="by" value="10m"><button>+10 min</button></form>
+<form method="post" action="/notes/{{.ID}}/extend"><input type="hidden" name="by" value="1h"><button>+1 hour</button></form>
+{{end}}
+</p>
+{{if .Requests}}
+<table>
+<tr><th>Time</th><th>Peer</th><th>Method</th><th>Status</th><th>Bytes</th><th>User-Agent</th></tr>
+{{range .Requests}}<tr><td>{{.Time}}</td><td>{{.Peer}}</td><td>{{.Method}}</td><td>{{.Status}}</td><td>{{.Bytes}}</td><td>{{.UserAgent}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p>Nobody has requested it yet.</p>
+{{end}}
+</div>
+</div>
+{{else}}
+<p>No pending notes. Add one with qreph add.</p>
+{{end}}
+<script>
+function tick() {
+  for (const el of document.querySelectorAll(".expires")) {
+    const left = Math.max(0, Math.round((new Date(el.dataset.at) - Date.now()) / 1000));
+    el.textContent = Math.floor(left / 60) + ":" + String(left % 60).padStart(2, "0");
+  }
+}
+tick();
+setInterval(tick, 1000);
+</script>
+</body>
+</html>
+`))
+
+type dashboardNote struct {
+	daemonNoteInfo
+	QR template.HTML
+}
+
+// serveDashboard serves the dashboard on addr, which has to be a loopback
+// address, and prints the URL to open it with.
+func (d *daemonServer) serveDashboard(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return errors.New("the dashboard only listens on loopback addresses")
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	token := rand.Text()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", d.dashboard)
+	mux.HandleFunc("POST /notes/{id}/revoke", func(w http.ResponseWriter, r *http.Request) {
+		if dn := d.lookup(r); dn != nil {
+			dn.revoke()
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+	mux.HandleFunc("POST /notes/{id}/extend", func(w http.ResponseWriter, r *http.Request) {
+		by, err := time.ParseDuration(r.FormValue("by"))
+		if dn := d.lookup(r); dn != nil && err == nil && by > 0 {
+			d.extendNote(dn, by)
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+	go func() {
+		if err := http.Serve(l, dashboardAuth(token, mux)); err != nil {
+			log.Fatalf("dashboard failed: %v", err)
+		}
+	}()
+	d.srv.opts.say("Dashboard: http://" + l.Addr().String() + "/?token=" + token)
+	return nil
+}
+
+// dashboardAuth lets through requests with the session cookie, or a
+// Bearer token for scripts. Visiting with ?token= sets the cookie.
+func dashboardAuth(token string, next http.Handler) http.Handler {
+	valid := func(s string) bool { return subtle.ConstantTimeCompare([]byte(s), []byte(token)) == 1 }
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t := r.URL.Query().Get("token"); t != "" && valid(t) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     "qreph_dashboard",
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		c, err := r.Cookie("qreph_dashboard")
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if (err != nil || !valid(c.Value)) && (!ok || !valid(bearer)) {
+			http.Error(w, "open the dashboard with the URL qreph daemon printed", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *daemonServer) dashboard(w http.ResponseWriter, r *http.Request) {
+	var page struct {
+		Stats daemonStats
+		Notes []dashboardNote
+	}
+	d.mu.Lock()
+	page.Stats = d.stats
+	page.Stats.Pending = len(d.ids)
+	d.mu.Unlock()
+	page.Stats.StartedAt = d.started.UTC().Format(time.RFC3339)
+	for _, dn := range d.pending() {
+		note := dashboardNote{daemonNoteInfo: d.info(dn)}
+		if svg, err := qr.SVG(note.URL, d.srv.opts.qr.Level); err == nil {
+			// Generated by us, with nothing from the note in it.
+			note.QR = template.HTML(svg)
+		}
+		page.Notes = append(page.Notes, note)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	dashboardPage.Execute(w, page)
+}
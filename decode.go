@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+
+	"github.com/kevinkokinda/qreph/pkg/qr"
+)
+
+func decode(args []string) {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph decode [image...]")
+		fmt.Fprintln(fs.Output(), "Prints the contents of the QR code in each PNG, JPEG or GIF image, or in the image on stdin.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	for _, path := range paths {
+		var r io.Reader = os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				log.Fatalf("failed to open image: %v", err)
+			}
+			defer f.Close()
+			r = f
+		}
+		img, _, err := image.Decode(r)
+		if err != nil {
+			log.Fatalf("failed to read image %s: %v", path, err)
+		}
+		text, err := qr.Decode(img)
+		if err != nil {
+			log.Fatalf("no QR code found in %s: %v", path, err)
+		}
+		fmt.Println(text)
+	}
+}
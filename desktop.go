@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// Desktop notifications go through the platform's own tools, like the
+// clipboard. Title and body are passed as arguments or in the environment
+// so they need no quoting.
+
+const windowsToast = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text[0].AppendChild($xml.CreateTextNode($env:QREPH_NOTIFY_TITLE)) > $null
+$text[1].AppendChild($xml.CreateTextNode($env:QREPH_NOTIFY_BODY)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('qreph').Show([Windows.UI.Notifications.ToastNotification]::new($xml))`
+
+func desktopNotify(title, body string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript",
+			"-e", "on run argv",
+			"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+			"-e", "end run",
+			title, body)
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", windowsToast)
+		cmd.Env = append(os.Environ(), "QREPH_NOTIFY_TITLE="+title, "QREPH_NOTIFY_BODY="+body)
+	default:
+		path, err := exec.LookPath("notify-send")
+		if err != nil {
+			return errors.New("notify-send not found (install libnotify)")
+		}
+		cmd = exec.Command(path, "--app-name=qreph", title, body)
+	}
+	return cmd.Run()
+}
+
+// openURL opens url in the default browser.
+func openURL(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Run()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Run()
+	}
+	return exec.Command("xdg-open", url).Run()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// eventLayouts are the ways --start and --end take a time, in the local
+// time zone unless it says otherwise. A date alone makes an all-day event.
+var eventLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+func parseEventTime(s string) (t time.Time, allDay bool, err error) {
+	for _, layout := range eventLayouts {
+		if t, err = time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, layout == "2006-01-02", nil
+		}
+	}
+	return t, false, fmt.Errorf("%q is not a time like 2006-01-02 15:04 or a date", s)
+}
+
+// eventEnd reads --end as a time, a time of day on the start date, or how
+// long the event lasts.
+func eventEnd(s string, start time.Time, allDay bool) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if allDay {
+			return time.Time{}, errors.New("an all-day event ends on a date")
+		}
+		return start.Add(d), nil
+	}
+	if clock, err := time.ParseInLocation("15:04", s, time.Local); err == nil && !allDay {
+		return time.Date(start.Year(), start.Month(), start.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local), nil
+	}
+	end, endAllDay, err := parseEventTime(s)
+	if err != nil {
+		return end, err
+	}
+	if endAllDay != allDay {
+		return end, errors.New("--start and --end must both be dates or both be times")
+	}
+	if allDay {
+		// DTEND of an all-day event is the day after the last one.
+		end = end.AddDate(0, 0, 1)
+	}
+	return end, nil
+}
+
+// foldLine splits an iCalendar content line into lines of at most 75
+// bytes, each continuation starting with a space.
+func foldLine(line string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		if n := len(string(r)); width+n > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += len(string(r))
+	}
+	return b.String()
+}
+
+// event builds a VEVENT and shows it as a direct QR code, which phone
+// cameras offer to add to the calendar, or with --serve serves it once as an
+// .ics file, for events too long for a code.
+func event(args []string) {
+	fs := flag.NewFlagSet("event", flag.ExitOnError)
+	title := fs.String("title", "", "event `name`")
+	startFlag := fs.String("start", "", "start `time`, e.g. \"2026-10-20 18:00\", or a date for an all-day event")
+	endFlag := fs.String("end", "", "end `time`, time of day or duration such as 90m (default an hour, or the one day)")
+	location := fs.String("location", "", "where the event takes `place`")
+	description := fs.String("description", "", "event `text`")
+	serve := fs.Bool("serve", false, "serve the event once as a calendar file instead of putting it in the QR code")
+	opts := addServeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph event --title name --start time [--end time] [--location place] [--description text] [--serve]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *title == "" || *startFlag == "" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if opts.tui {
+		log.Fatal("qreph event has no --tui")
+	}
+
+	start, allDay, err := parseEventTime(*startFlag)
+	if err != nil {
+		log.Fatalf("invalid --start: %v", err)
+	}
+	var end time.Time
+	switch {
+	case *endFlag != "":
+		if end, err = eventEnd(*endFlag, start, allDay); err != nil {
+			log.Fatalf("invalid --end: %v", err)
+		}
+	case allDay:
+		end = start.AddDate(0, 0, 1)
+	default:
+		end = start.Add(time.Hour)
+	}
+	if !end.After(start) {
+		log.Fatal("the event has to end after it starts")
+	}
+
+	lines := []string{"BEGIN:VEVENT"}
+	if *serve {
+		lines = append(lines, "UID:"+rand.Text()+"@qreph", "DTSTAMP:"+time.Now().UTC().Format("20060102T150405Z"))
+	}
+	lines = append(lines, "SUMMARY:"+vcardEscaper.Replace(*title))
+	if allDay {
+		lines = append(lines, "DTSTART;VALUE=DATE:"+start.Format("20060102"), "DTEND;VALUE=DATE:"+end.Format("20060102"))
+	} else {
+		lines = append(lines, "DTSTART:"+start.UTC().Format("20060102T150405Z"), "DTEND:"+end.UTC().Format("20060102T150405Z"))
+	}
+	if *location != "" {
+		lines = append(lines, "LOCATION:"+vcardEscaper.Replace(*location))
+	}
+	if *description != "" {
+		lines = append(lines, "DESCRIPTION:"+vcardEscaper.Replace(*description))
+	}
+	lines = append(lines, "END:VEVENT")
+
+	if !*serve {
+		opts.qr.showDirect("Scan to add the event:", strings.Join(lines, "\r\n"))
+		return
+	}
+	lines = append(append([]string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//qreph//event//EN"}, lines...), "END:VCALENDAR")
+	for i, line := range lines {
+		lines[i] = foldLine(line)
+	}
+	n := &share.Note{Content: []byte(strings.Join(lines, "\r\n") + "\r\n"), ContentType: "text/calendar; charset=utf-8"}
+	serveNote(opts, n, 1, "Serving event at:")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+func fetch(args []string) {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	code := fs.String("code", "", "code `phrase` shown by the sender (prompted for if empty)")
+	outDir := fs.String("o", ".", "write files to `dir`, or - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph fetch [--code phrase] [-o dir] <url>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if *code == "" {
+		*code = strings.TrimSpace(prompt("Code phrase"))
+	}
+	p, err := share.FetchCode(fs.Arg(0), *code)
+	if errors.Is(err, share.ErrWrongCode) {
+		log.Fatal(err)
+	}
+	if err != nil {
+		log.Fatalf("failed to fetch note: %v", err)
+	}
+	savePayload(p, *outDir)
+}
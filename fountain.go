@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"math/bits"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// Animated mode cycles through QR frames of a rateless code: the first k
+// frames carry the blocks of the payload, every later one the XOR of a
+// pseudorandom subset of them. Any k or slightly more distinct frames, in
+// any order, are enough to rebuild the payload, so a receiver that misses
+// frames just keeps watching.
+
+const framePrefix = "QF1:"
+
+// maxFrameBlocks bounds a transfer, which takes the decoder about
+// blocks²/8 bytes; at the default frame size it is 2 MB, or an hour of
+// frames.
+const maxFrameBlocks = 1 << 14
+
+type frameHeader struct {
+	blocks int
+	length int
+	crc    uint32
+	index  int
+}
+
+// frameCoefficients returns the set of blocks XORed into frame index as a
+// bitset.
+func frameCoefficients(blocks, index int) []uint64 {
+	coef := make([]uint64, (blocks+63)/64)
+	if index < blocks {
+		coef[index/64] = 1 << (index % 64)
+		return coef
+	}
+	r := rand.New(rand.NewPCG(uint64(index), uint64(blocks)))
+	zero := true
+	for i := range coef {
+		coef[i] = r.Uint64()
+		if i == len(coef)-1 && blocks%64 != 0 {
+			coef[i] &= 1<<(blocks%64) - 1
+		}
+		zero = zero && coef[i] == 0
+	}
+	if zero {
+		coef[0] = 1
+	}
+	return coef
+}
+
+type frameEncoder struct {
+	hdr    frameHeader
+	blocks [][]byte
+}
+
+func newFrameEncoder(payload []byte, blockSize int) *frameEncoder {
+	e := &frameEncoder{hdr: frameHeader{length: len(payload), crc: crc32.ChecksumIEEE(payload)}}
+	for i := 0; i < len(payload); i += blockSize {
+		block := make([]byte, blockSize)
+		copy(block, payload[i:])
+		e.blocks = append(e.blocks, block)
+	}
+	e.hdr.blocks = len(e.blocks)
+	return e
+}
+
+func (e *frameEncoder) frame(index int) string {
+	coef := frameCoefficients(len(e.blocks), index)
+	data := make([]byte, len(e.blocks[0]))
+	for i, block := range e.blocks {
+		if coef[i/64]&(1<<(i%64)) != 0 {
+			xorBytes(data, block)
+		}
+	}
+	return fmt.Sprintf("%s%d:%d:%08x:%d:%s", framePrefix, e.hdr.blocks, e.hdr.length, e.hdr.crc, index, base64.RawURLEncoding.EncodeToString(data))
+}
+
+func xorBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+func parseFrame(s string) (frameHeader, []byte, error) {
+	var h frameHeader
+	rest, ok := strings.CutPrefix(strings.TrimSpace(s), framePrefix)
+	parts := strings.Split(rest, ":")
+	if !ok || len(parts) != 5 {
+		return h, nil, errors.New("not a qreph frame")
+	}
+	var err error
+	var crc uint64
+	if h.blocks, err = strconv.Atoi(parts[0]); err != nil || h.blocks < 1 {
+		return h, nil, errors.New("bad block count")
+	}
+	if h.length, err = strconv.Atoi(parts[1]); err != nil || h.length < 1 {
+		return h, nil, errors.New("bad length")
+	}
+	if crc, err = strconv.ParseUint(parts[2], 16, 32); err != nil {
+		return h, nil, errors.New("bad checksum")
+	}
+	h.crc = uint32(crc)
+	if h.index, err = strconv.Atoi(parts[3]); err != nil || h.index < 0 {
+		return h, nil, errors.New("bad frame index")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[4])
+	if err != nil {
+		return h, nil, err
+	}
+	if len(data) == 0 || h.blocks > maxFrameBlocks || h.blocks != (h.length+len(data)-1)/len(data) {
+		return h, nil, errors.New("block count does not match the length")
+	}
+	return h, data, nil
+}
+
+// frameDecoder runs Gaussian elimination over GF(2) as frames arrive,
+// keeping the rows fully reduced so that every pivot row ends up holding
+// exactly one block.
+type frameDecoder struct {
+	hdr  frameHeader
+	size int
+	seen map[int]bool
+	rows map[int]*frameRow // by pivot
+}
+
+type frameRow struct {
+	coef []uint64
+	data []byte
+}
+
+// add feeds one frame and reports whether the payload is complete.
+func (d *frameDecoder) add(h frameHeader, data []byte) (bool, error) {
+	if d.rows == nil {
+		d.hdr, d.size, d.seen, d.rows = h, len(data), make(map[int]bool), make(map[int]*frameRow)
+	}
+	if h.blocks != d.hdr.blocks || h.length != d.hdr.length || h.crc != d.hdr.crc || len(data) != d.size {
+		return false, errors.New("frame belongs to a different transfer")
+	}
+	if d.seen[h.index] || len(d.rows) == h.blocks {
+		return len(d.rows) == h.blocks, nil
+	}
+	d.seen[h.index] = true
+
+	row := &frameRow{coef: frameCoefficients(h.blocks, h.index), data: data}
+	for p, r := range d.rows {
+		if row.coef[p/64]&(1<<(p%64)) != 0 {
+			row.xor(r)
+		}
+	}
+	pivot := row.pivot()
+	if pivot < 0 {
+		return false, nil // carries nothing new
+	}
+	for p, r := range d.rows {
+		if r.coef[pivot/64]&(1<<(pivot%64)) != 0 {
+			d.rows[p].xor(row)
+		}
+	}
+	d.rows[pivot] = row
+	return len(d.rows) == h.blocks, nil
+}
+
+func (r *frameRow) xor(o *frameRow) {
+	for i := range r.coef {
+		r.coef[i] ^= o.coef[i]
+	}
+	xorBytes(r.data, o.data)
+}
+
+func (r *frameRow) pivot() int {
+	for i, w := range r.coef {
+		if w != 0 {
+			return i*64 + bits.TrailingZeros64(w)
+		}
+	}
+	return -1
+}
+
+func (d *frameDecoder) sealedPayload() (*share.Payload, error) {
+	var buf bytes.Buffer
+	for i := range d.hdr.blocks {
+		buf.Write(d.rows[i].data)
+	}
+	if buf.Len() < d.hdr.length {
+		return nil, errors.New("frames have inconsistent sizes")
+	}
+	payload := buf.Bytes()[:d.hdr.length]
+	if crc32.ChecksumIEEE(payload) != d.hdr.crc {
+		return nil, errors.New("checksum mismatch")
+	}
+	var p share.Payload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// animate shows n as an endless loop of frames until interrupted.
+func animate(n *share.Note, qrOpts *qrOptions, blockSize, fps int) {
+	payload, err := n.MarshalPayload()
+	if err != nil {
+		log.Fatalf("failed to read note: %v", err)
+	}
+	e := newFrameEncoder(payload, blockSize)
+	if e.hdr.blocks > maxFrameBlocks {
+		log.Fatalf("the note needs %d frames, more than %d; raise --frame-size", e.hdr.blocks, maxFrameBlocks)
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(fps))
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		fmt.Print("\x1b[H\x1b[2J")
+		fmt.Printf("Frame %d, about %d needed by qreph assemble (Ctrl-C to stop)\n", i+1, e.hdr.blocks)
+		if err := qrOpts.Render(os.Stdout, e.frame(i)); err != nil {
+			log.Fatalf("failed to draw QR code: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// assemble reads scanned frames, one per line, until the payload is
+// complete.
+func assemble(args []string) {
+	fs := flag.NewFlagSet("assemble", flag.ExitOnError)
+	outDir := fs.String("o", ".", "write files to `dir`, or - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph assemble [-o dir] [file...]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	var lines []*bufio.Scanner
+	if fs.NArg() == 0 {
+		lines = append(lines, bufio.NewScanner(os.Stdin))
+	}
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("failed to open frames: %v", err)
+		}
+		defer f.Close()
+		lines = append(lines, bufio.NewScanner(f))
+	}
+
+	var d frameDecoder
+	for _, sc := range lines {
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			h, data, err := parseFrame(sc.Text())
+			if err != nil {
+				continue
+			}
+			complete, err := d.add(h, data)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !complete {
+				fmt.Fprintf(os.Stderr, "\r%d/%d blocks", len(d.rows), h.blocks)
+				continue
+			}
+			fmt.Fprintln(os.Stderr)
+			p, err := d.sealedPayload()
+			if err != nil {
+				log.Fatalf("failed to assemble note: %v", err)
+			}
+			savePayload(p, *outDir)
+			return
+		}
+	}
+	if d.rows == nil {
+		log.Fatal("no qreph frames found")
+	}
+	log.Fatalf("ran out of frames with %d/%d blocks", len(d.rows), d.hdr.blocks)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// Frames arrive shuffled and a third of them never make it, as when a
+// camera misses some of the loop.
+func TestFrameRoundTripLossy(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		blockSize int
+	}{
+		{"one block", 10, 128},
+		{"partial last block", 1000, 64},
+		{"many blocks", 20000, 100},
+		{"a word of blocks", 64*32 - 40, 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rand.New(rand.NewPCG(uint64(tt.size), uint64(tt.blockSize)))
+			content := make([]byte, tt.size)
+			for i := range content {
+				content[i] = byte(r.Uint32())
+			}
+			n := &share.Note{Content: content, ContentType: "application/octet-stream", Filename: "x.bin"}
+			payload, err := n.MarshalPayload()
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := newFrameEncoder(payload, tt.blockSize)
+
+			order := r.Perm(3*e.hdr.blocks + 20)
+			var d frameDecoder
+			complete := false
+			used := 0
+			for _, i := range order {
+				if r.IntN(3) == 0 {
+					continue
+				}
+				h, data, err := parseFrame(e.frame(i))
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				used++
+				if complete, err = d.add(h, data); err != nil {
+					t.Fatal(err)
+				}
+				if complete {
+					break
+				}
+			}
+			if !complete {
+				t.Fatalf("not complete after %d frames with %d/%d blocks", used, len(d.rows), e.hdr.blocks)
+			}
+			p, err := d.sealedPayload()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(p.Data, content) || p.Name != "x.bin" {
+				t.Fatalf("got %d bytes named %q", len(p.Data), p.Name)
+			}
+		})
+	}
+}
+
+func TestParseFrameRejects(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"QF1:1:5:00000000:0",
+		"QF1:0:5:00000000:0:aGVsbG8",
+		"QF1:2:5:00000000:0:aGVsbG8", // one 5-byte block holds it all
+		"QF1:1:6:00000000:0:aGVsbG8", // needs two blocks
+		"QF1:1:1:00000000:0:",        // no data
+		"QF1:100000000:500000000:00000000:0:aGVsbG8",
+	} {
+		if _, _, err := parseFrame(s); err == nil {
+			t.Errorf("parseFrame(%q) succeeded", s)
+		}
+	}
+	if h, _, err := parseFrame("QF1:2:6:00000000:1:aGVsbG8"); err != nil || h.blocks != 2 {
+		t.Errorf("parseFrame of a good frame = %+v, %v", h, err)
+	}
+}
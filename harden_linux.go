@@ -0,0 +1,13 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// harden turns off core dumps and makes the process undumpable, which also
+// keeps other processes of the same user from attaching with ptrace or
+// reading its memory through /proc.
+func harden() error {
+	if err := unix.Setrlimit(unix.RLIMIT_CORE, &unix.Rlimit{}); err != nil {
+		return err
+	}
+	return unix.Prctl(unix.PR_SET_DUMPABLE, 0, 0, 0, 0)
+}
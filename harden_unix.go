@@ -0,0 +1,11 @@
+//go:build !linux && !windows
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// harden turns off core dumps. Keeping debuggers out takes more than that
+// outside Linux, so it is left to the system.
+func harden() error {
+	return unix.Setrlimit(unix.RLIMIT_CORE, &unix.Rlimit{})
+}
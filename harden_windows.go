@@ -0,0 +1,7 @@
+package main
+
+import "errors"
+
+func harden() error {
+	return errors.New("not supported on Windows")
+}
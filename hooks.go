@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// deliveryHooks tell the outside world when a note has been fetched.
+type deliveryHooks struct {
+	notifyURL string
+	command   string
+	desktop   bool
+	running   sync.WaitGroup
+}
+
+func addHookFlags(fs *flag.FlagSet) *deliveryHooks {
+	h := &deliveryHooks{}
+	fs.StringVar(&h.notifyURL, "notify-url", "", "POST a JSON event to `url` every time the note is fetched")
+	fs.BoolVar(&h.desktop, "desktop-notify", false, "show a desktop notification every time the note is fetched")
+	fs.StringVar(&h.command, "on-download", "", "run the shell `command` every time the note is fetched, with QREPH_PEER, QREPH_USER_AGENT, QREPH_BYTES and QREPH_RETRIEVED_AT set")
+	return h
+}
+
+// options returns the share options that run the hooks, if any are set.
+func (h *deliveryHooks) options() []share.Option {
+	if h.notifyURL == "" && h.command == "" && !h.desktop {
+		return nil
+	}
+	return []share.Option{share.WithOnDelivery(h.delivered)}
+}
+
+// delivered starts the hooks for d. They run in the background so the
+// client gets its response right away, wait holds the exit until they are
+// through.
+func (h *deliveryHooks) delivered(d share.Delivery) {
+	if h.notifyURL != "" {
+		h.background(func() { h.notify(d) })
+	}
+	if h.command != "" {
+		h.background(func() { h.run(d) })
+	}
+	if h.desktop {
+		body := "The note was fetched"
+		if d.Peer != "" {
+			body += " by " + d.Peer
+		}
+		h.background(func() {
+			if err := desktopNotify("qreph", body); err != nil {
+				log.Printf("failed to show desktop notification: %v", err)
+			}
+		})
+	}
+}
+
+func (h *deliveryHooks) background(f func()) {
+	h.running.Add(1)
+	go func() {
+		defer h.running.Done()
+		f()
+	}()
+}
+
+func (h *deliveryHooks) wait() {
+	h.running.Wait()
+}
+
+// notify POSTs d to --notify-url.
+func (h *deliveryHooks) notify(d share.Delivery) {
+	event := map[string]any{
+		"retrieved_at": d.Time.UTC().Format(time.RFC3339),
+		"bytes":        d.Bytes,
+	}
+	if d.Peer != "" {
+		event["peer"] = d.Peer
+		event["user_agent"] = d.UserAgent
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(h.notifyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("failed to notify %s: %v", h.notifyURL, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("failed to notify %s: %s", h.notifyURL, resp.Status)
+	}
+}
+
+// run runs --on-download with the details of d in its environment. Its
+// output goes to stderr to keep stdout for the URL.
+func (h *deliveryHooks) run(d share.Delivery) {
+	cmd := exec.Command("sh", "-c", h.command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", h.command)
+	}
+	cmd.Env = append(os.Environ(),
+		"QREPH_PEER="+d.Peer,
+		"QREPH_USER_AGENT="+d.UserAgent,
+		"QREPH_BYTES="+strconv.FormatInt(d.Bytes, 10),
+		"QREPH_RETRIEVED_AT="+d.Time.UTC().Format(time.RFC3339),
+	)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("--on-download command failed: %v", err)
+	}
+}
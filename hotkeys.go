@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/term"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// switchMux serves a handler at one random path, and can move it to a new
+// one so a URL that may have leaked stops working.
+type switchMux struct {
+	current atomic.Pointer[http.ServeMux]
+	fixed   string // the one path to use instead
+}
+
+func (m *switchMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.current.Load().ServeHTTP(w, r)
+}
+
+func (m *switchMux) Handler(r *http.Request) (http.Handler, string) {
+	return m.current.Load().Handler(r)
+}
+
+// move serves the handler for a new random path instead of the old one.
+func (m *switchMux) move(handler func(path string) http.Handler) string {
+	path := m.fixed
+	if path == "" {
+		path, _ = share.RandomPath()
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, handler(path))
+	m.current.Store(mux)
+	return path
+}
+
+// terminalRestore puts the terminal back while hotkeys has it.
+var terminalRestore atomic.Pointer[func()]
+
+// fatalf is log.Fatalf for what can fail while hotkeys has the terminal,
+// which the deferred restore would otherwise leave without echo.
+func fatalf(format string, v ...any) {
+	if restore := terminalRestore.Load(); restore != nil {
+		(*restore)()
+	}
+	log.Fatalf(format, v...)
+}
+
+// hotkeys reads key presses from the terminal while the server waits, if
+// stdin is one. restore puts the terminal back.
+func (s *server) hotkeys() (keys <-chan byte, restore func()) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, func() {}
+	}
+	put, err := cbreak(os.Stdin)
+	if err != nil {
+		return nil, func() {}
+	}
+	put = sync.OnceFunc(put)
+	terminalRestore.Store(&put)
+	if !s.opts.json && s.opts.verbosity >= 0 && s.opts.screen == nil {
+		fmt.Fprintln(s.opts.qr.human(), "Keys: r new URL, c copy URL, o open in browser, q quit")
+	}
+	ch := make(chan byte)
+	go func() {
+		b := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(b); err != nil {
+				return
+			}
+			ch <- b[0]
+		}
+	}()
+	return ch, func() {
+		terminalRestore.Store(nil)
+		put()
+	}
+}
+
+// hotkey acts on key and reports whether it asked to quit.
+func (s *server) hotkey(key byte) (quit bool) {
+	switch key {
+	case 'r':
+		s.move()
+	case 'c':
+		if err := writeClipboard([]byte(s.urls[0])); err != nil {
+			log.Printf("failed to copy URL: %v", err)
+		} else {
+			s.opts.say("Copied the URL.")
+		}
+	case 'o':
+		if err := openURL(s.urls[0]); err != nil {
+			log.Printf("failed to open URL: %v", err)
+		}
+	case 'q', 3: // Ctrl-C arrives as a key on Windows.
+		return true
+	}
+	return false
+}
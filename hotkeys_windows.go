@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// cbreak puts the console f in raw mode, which on Windows only changes how
+// input is read.
+func cbreak(f *os.File) (restore func(), err error) {
+	state, err := term.MakeRaw(int(f.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	return func() { term.Restore(int(f.Fd()), state) }, nil
+}
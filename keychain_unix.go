@@ -0,0 +1,44 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// readKeychain returns the secret of the item called name: from the macOS
+// Keychain by service or label, or elsewhere from the Secret Service
+// (GNOME Keyring, KWallet) through libsecret's secret-tool, by its service
+// attribute.
+func readKeychain(name string) ([]byte, error) {
+	var cmds [][]string
+	if runtime.GOOS == "darwin" {
+		cmds = [][]string{{"security", "find-generic-password", "-w", "-s", name}, {"security", "find-generic-password", "-w", "-l", name}}
+	} else {
+		cmds = [][]string{{"secret-tool", "lookup", "service", name}}
+	}
+	path, err := exec.LookPath(cmds[0][0])
+	if err != nil {
+		return nil, errors.New("secret-tool not found (install libsecret-tools)")
+	}
+	err = fmt.Errorf("no item %q", name)
+	for _, c := range cmds {
+		out, runErr := exec.Command(path, c[1:]...).Output()
+		if runErr == nil && len(out) > 0 {
+			if runtime.GOOS == "darwin" {
+				// security ends the password with a newline.
+				out = bytes.TrimSuffix(out, []byte("\n"))
+			}
+			return out, nil
+		}
+		var exit *exec.ExitError
+		if errors.As(runErr, &exit) && len(bytes.TrimSpace(exit.Stderr)) > 0 {
+			err = fmt.Errorf("%s: %s", c[0], bytes.TrimSpace(exit.Stderr))
+		}
+	}
+	return nil, err
+}
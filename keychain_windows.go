@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	advapi32     = windows.NewLazySystemDLL("advapi32.dll")
+	procCredRead = advapi32.NewProc("CredReadW")
+	procCredFree = advapi32.NewProc("CredFree")
+)
+
+const credTypeGeneric = 1
+
+// credential is CREDENTIALW.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// readKeychain returns the password of the generic credential called name
+// in the Windows Credential Manager.
+func readKeychain(name string) ([]byte, error) {
+	target, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	var cred *credential
+	if r, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); r == 0 {
+		if err == windows.ERROR_NOT_FOUND {
+			return nil, fmt.Errorf("no item %q", name)
+		}
+		return nil, err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+	defer clear(blob)
+	// The Credential Manager and cmdkey store UTF-16, other tools UTF-8,
+	// which never has NUL bytes.
+	if len(blob)%2 == 0 && bytes.IndexByte(blob, 0) >= 0 {
+		units := unsafe.Slice((*uint16)(unsafe.Pointer(cred.CredentialBlob)), len(blob)/2)
+		return []byte(string(utf16.Decode(units))), nil
+	}
+	return bytes.Clone(blob), nil
+}
@@ -1,143 +1,80 @@
 package main
 
 import (
-	"context"
-	"crypto/rand"
-	"encoding/base64"
-	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net"
-	"net/http"
 	"os"
-	"os/signal"
-	"strings"
-	"sync"
-	"syscall"
-	"time"
-
-	"github.com/mdp/qrterminal/v3"
 )
 
-type noteStore struct {
-	content []byte
-	once    sync.Once
-	mu      sync.Mutex
-}
+// Exit codes of the commands that serve something, so scripts can tell
+// whether it reached anyone.
+const (
+	exitFetched     = 0
+	exitFailed      = 1 // log.Fatal
+	exitUsage       = 2 // the flag package
+	exitNotFetched  = 3 // expired or destroyed before the first fetch
+	exitInterrupted = 4 // stopped by a signal before the first fetch
+	exitListen      = 5 // could not listen on the port
+)
 
-func (s *noteStore) get() []byte {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	var content []byte
-	s.once.Do(func() {
-		content = s.content
-		s.content = nil
-	})
-	return content
+// commands are the subcommands in the order the usage lists them.
+var commands = []struct {
+	name, usage string
+	run         func(args []string)
+}{
+	{"send", "serve a note once and show its URL as a QR code", send},
+	{"receive", "take files or text uploaded from a phone", receive},
+	{"fetch", "download a note protected with a code phrase", fetch},
+	{"qr", "draw a QR code for text, without serving anything", qrCommand},
+	{"decode", "print the contents of QR codes in images", decode},
+	{"scan", "read a QR code from the webcam", scan},
+	{"assemble", "put --animate frames back together", assemble},
+	{"split", "split a secret into QR codes, some of which recover it", split},
+	{"combine", "recover a secret from qreph split shares", combine},
+	{"verify", "check the signature of a note", verify},
+	{"watch-clip", "serve the clipboard every time it changes", watchClip},
+	{"wifi", "show a QR code that joins a Wi-Fi network", wifi},
+	{"vcard", "show a contact as a QR code", vcard},
+	{"totp", "show an authenticator enrollment QR code", totp},
+	{"tel", "show a phone number to call as a QR code", tel},
+	{"sms", "show a text message to send as a QR code", sms},
+	{"mailto", "show an email to write as a QR code", mailto},
+	{"geo", "show a map location as a QR code", geo},
+	{"event", "show a calendar event as a QR code", event},
+	{"ssh-key", "serve an SSH public key with the command that authorizes it", sshKey},
+	{"relay", "run a relay for qreph send --relay", relay},
+	{"daemon", "keep one server up for notes added with qreph add", daemon},
+	{"add", "serve a note from the running qreph daemon", add},
 }
 
-func getOutboundIP() (net.IP, error) {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-
-	localAddr, ok := conn.LocalAddr().(*net.UDPAddr)
-	if !ok {
-		return nil, errors.New("could not assert type to *net.UDPAddr")
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: qreph <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-11s %s\n", c.name, c.usage)
 	}
-
-	return localAddr.IP, nil
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Without a command, qreph runs send. Run qreph <command> -h for its flags.")
 }
 
 func main() {
 	log.SetFlags(0)
 
-	stat, err := os.Stdin.Stat()
-	if err != nil {
-		log.Fatalf("failed to stat stdin: %v", err)
-	}
-
-	var content []byte
-	if (stat.Mode() & os.ModeCharDevice) == 0 {
-		content, err = io.ReadAll(os.Stdin)
-		if err != nil {
-			log.Fatalf("failed to read from stdin: %v", err)
-		}
-	} else {
-		if len(os.Args) < 2 {
-			fmt.Println("usage: qreph <text> | <command> | qreph")
-			return
-		}
-		content = []byte(strings.Join(os.Args[1:], " "))
-	}
-
-	if len(content) == 0 {
-		log.Fatal("no content provided")
-	}
-
-	store := &noteStore{content: content}
-
-	randomBytes := make([]byte, 32)
-	if _, err := rand.Read(randomBytes); err != nil {
-		log.Fatalf("failed to generate random bytes: %v", err)
-	}
-	path := "/" + base64.URLEncoding.EncodeToString(randomBytes)
-
-	done := make(chan struct{})
-
-	mux := http.NewServeMux()
-	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-		note := store.get()
-		if note == nil {
-			http.NotFound(w, r)
+	if len(os.Args) > 1 {
+		switch arg := os.Args[1]; arg {
+		case "help", "-h", "-help", "--help":
+			usage()
 			return
+		default:
+			for _, c := range commands {
+				if c.name == arg {
+					c.run(os.Args[2:])
+					return
+				}
+			}
 		}
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.Write(note)
-		close(done)
-	})
-
-	server := &http.Server{
-		Handler: mux,
-	}
-
-	listener, err := net.Listen("tcp", ":0")
-	if err != nil {
-		log.Fatalf("failed to create listener: %v", err)
-	}
-	port := listener.Addr().(*net.TCPAddr).Port
-
-	go func() {
-		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("server failed: %v", err)
-		}
-	}()
-
-	ip, err := getOutboundIP()
-	if err != nil {
-		log.Fatalf("failed to get outbound ip: %v", err)
-	}
-
-	url := fmt.Sprintf("http://%s:%d%s", ip, port, path)
-
-	fmt.Println("Serving note at:", url)
-	qrterminal.Generate(url, qrterminal.L, os.Stdout)
-
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-
-	select {
-	case <-done:
-	case <-stop:
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := server.Shutdown(ctx); err != nil {
-		log.Printf("server shutdown failed: %v", err)
 	}
+	// "qreph text" and "qreph -f file" predate the subcommands.
+	send(os.Args[1:])
 }
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// lockMemory keeps b out of swap.
+func lockMemory(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return unix.Mlock(b)
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockMemory keeps b out of the page file.
+func lockMemory(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return windows.VirtualLock(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"filippo.io/age"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// sendMany serves each of notes at a one-time URL of its own from one
+// server, shows a QR code per note and returns once all of them are gone.
+func sendMany(notes []*share.Note, names []string, recipients []age.Recipient, storeOpts []share.Option, filter func(http.Handler) http.Handler, opts *serveOptions, hooks *deliveryHooks) {
+	srv := newServer(opts)
+	var fragment string
+	if srv.Cert() != nil {
+		fragment = share.SPKIPin(srv.Cert())
+	}
+
+	mux := http.NewServeMux()
+	stores := make([]*share.Store, len(notes))
+	paths := make([]string, len(notes))
+	var pending sync.WaitGroup
+	for i, n := range notes {
+		if n.Stream == nil {
+			sum := sha256.Sum256(n.Content)
+			n.Sum = hex.EncodeToString(sum[:])
+		}
+		if len(recipients) > 0 {
+			n = share.Age(n, recipients)
+		}
+		stores[i] = share.NewStore(n, storeOpts...)
+		done := make(chan struct{})
+		var handler http.Handler = share.NoteHandler(stores[i], done)
+		if srv.Cert() != nil {
+			handler = share.PinnedHandler(stores[i], srv.Cert(), handler, done)
+		} else {
+			handler = share.ConfirmHandler(handler)
+		}
+		paths[i], _ = share.RandomPath()
+		mux.Handle(paths[i], filter(handler))
+
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			<-done
+			opts.say(names[i] + " was fetched.")
+		}()
+	}
+	allDone := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(allDone)
+	}()
+
+	srv.start(mux)
+	for i := range notes {
+		srv.announce(fmt.Sprintf("Serving %s at:", names[i]), paths[i], fragment)
+	}
+	code := srv.wait(allDone)
+	srv.stop()
+	fetched := false
+	for _, store := range stores {
+		store.Burn()
+		fetched = fetched || store.Fetches() > 0
+	}
+	hooks.wait()
+	if fetched {
+		code = exitFetched
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kevinkokinda/qreph/pkg/qr"
+)
+
+// addPaperFlag adds --paper to the commands whose codes are worth keeping
+// on paper: they hold the content itself rather than a URL.
+func (o *qrOptions) addPaperFlag(fs *flag.FlagSet) {
+	fs.StringVar(&o.paper, "paper", "", "also write a printable PDF to `file` with the QR code, the content in base32, its checksum and the date")
+}
+
+// paperPage lays out text for --paper: the code, and what it takes to
+// check or recover the content without a scanner.
+func paperPage(title, text string) qr.Page {
+	sum := sha256.Sum256([]byte(text))
+	lines := []string{
+		"Created  " + time.Now().Format("2006-01-02 15:04 MST"),
+		"SHA-256  " + groups(hex.EncodeToString(sum[:]), 8, 16),
+		"",
+		"If the code does not scan, type this in, drop the spaces and decode it with base32 -d:",
+		"",
+	}
+	encoded := base32.StdEncoding.EncodeToString([]byte(text))
+	for len(encoded) > 0 {
+		n := min(len(encoded), 64)
+		lines = append(lines, groups(encoded[:n], 4, 16))
+		encoded = encoded[n:]
+	}
+	return qr.Page{Title: title, Text: text, Lines: lines}
+}
+
+// groups puts a space after every size characters of s, up to perLine
+// groups.
+func groups(s string, size, perLine int) string {
+	var parts []string
+	for len(s) > 0 && len(parts) < perLine {
+		n := min(len(s), size)
+		parts = append(parts, s[:n])
+		s = s[n:]
+	}
+	return strings.Join(parts, " ")
+}
+
+// writePaper writes pages to --paper, if it was given.
+func (o *qrOptions) writePaper(pages ...qr.Page) {
+	if o.paper == "" {
+		return
+	}
+	if err := qr.WritePDF(o.paper, pages, o.Level); err != nil {
+		log.Fatalf("failed to write paper backup: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// readPass returns the password kept in entry of the password store, the
+// first line, which is what pass -c copies. gpg may ask for its passphrase
+// on the terminal, so the tool gets stdin and stderr.
+func readPass(entry string) ([]byte, error) {
+	for _, c := range [][]string{{"pass", "show", "--", entry}, {"gopass", "show", "--password", "--", entry}} {
+		path, err := exec.LookPath(c[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, c[1:]...)
+		cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", c[0], err)
+		}
+		line, _, _ := bytes.Cut(out, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		clear(out[len(line):])
+		if len(line) == 0 {
+			return nil, fmt.Errorf("%s has no password", entry)
+		}
+		return line, nil
+	}
+	return nil, errors.New("neither pass nor gopass found")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// A noteDB keeps the pending notes of the daemon in a bbolt file, so they
+// survive a crash or reboot. Each note is sealed with AES-256-GCM under a
+// key derived from a passphrase with scrypt; the file holds only the salt,
+// the sealed notes and the sealed --tls and --link-ttl keys, and the key
+// lives in memory.
+
+var (
+	metaBucket  = []byte("meta")
+	notesBucket = []byte("notes")
+	saltKey     = []byte("salt")
+	checkKey    = []byte("check")
+	tlsKeyKey   = []byte("tls_key")
+	linkKeyKey  = []byte("link_key")
+	checkValue  = []byte("qreph")
+)
+
+type noteDB struct {
+	db   *bolt.DB
+	aead cipher.AEAD
+}
+
+// storedNote is what the noteDB keeps of a pending note. The path is
+// stored so its URL keeps working after a restart.
+type storedNote struct {
+	ID          int       `json:"id"`
+	Path        string    `json:"path"`
+	Content     []byte    `json:"content"`
+	ContentType string    `json:"content_type"`
+	Filename    string    `json:"filename,omitempty"`
+	MaxFetches  int       `json:"max_fetches"`
+	Fetches     int       `json:"fetches"`
+	Added       time.Time `json:"added"`
+	Expires     time.Time `json:"expires"`
+}
+
+// openNoteDB opens or creates the store at path. A wrong passphrase is an
+// error rather than a store that cannot decrypt anything.
+func openNoteDB(path, passphrase string) (*noteDB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is in use by another daemon", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &noteDB{db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(notesBucket); err != nil {
+			return err
+		}
+		salt := meta.Get(saltKey)
+		fresh := salt == nil
+		if fresh {
+			salt = make([]byte, 16)
+			rand.Read(salt)
+			if err := meta.Put(saltKey, salt); err != nil {
+				return err
+			}
+		}
+		key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+		if err != nil {
+			return err
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		if s.aead, err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+		if fresh {
+			return meta.Put(checkKey, s.seal(checkValue, checkKey))
+		}
+		if check, err := s.open(meta.Get(checkKey), checkKey); err != nil || !bytes.Equal(check, checkValue) {
+			return errors.New("wrong passphrase")
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *noteDB) Close() error {
+	return s.db.Close()
+}
+
+// tlsKey returns the key for the --tls certificate, made on first use, so
+// the pin in the URLs of stored notes still matches after a restart.
+func (s *noteDB) tlsKey() (*ecdsa.PrivateKey, error) {
+	var key *ecdsa.PrivateKey
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if sealed := meta.Get(tlsKeyKey); sealed != nil {
+			der, err := s.open(sealed, tlsKeyKey)
+			if err != nil {
+				return err
+			}
+			key, err = x509.ParseECPrivateKey(der)
+			return err
+		}
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		return meta.Put(tlsKeyKey, s.seal(der, tlsKeyKey))
+	})
+	return key, err
+}
+
+// linkKey returns the key that signs --link-ttl URLs, made on first use.
+func (s *noteDB) linkKey() ([]byte, error) {
+	var key []byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if sealed := meta.Get(linkKeyKey); sealed != nil {
+			var err error
+			key, err = s.open(sealed, linkKeyKey)
+			return err
+		}
+		key = make([]byte, 32)
+		rand.Read(key)
+		return meta.Put(linkKeyKey, s.seal(key, linkKeyKey))
+	})
+	return key, err
+}
+
+// seal encrypts plaintext bound to key, so sealed notes cannot be swapped
+// between ids.
+func (s *noteDB) seal(plaintext, key []byte) []byte {
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(plaintext)+s.aead.Overhead())
+	rand.Read(nonce)
+	return s.aead.Seal(nonce, nonce, plaintext, key)
+}
+
+func (s *noteDB) open(sealed, key []byte) ([]byte, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, errors.New("truncated record")
+	}
+	return s.aead.Open(nil, sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():], key)
+}
+
+func noteKey(id int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+// put adds or replaces n.
+func (s *noteDB) put(n storedNote) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	defer clear(data)
+	key := noteKey(n.ID)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(notesBucket).Put(key, s.seal(data, key))
+	})
+}
+
+func (s *noteDB) delete(id int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(notesBucket).Delete(noteKey(id))
+	})
+}
+
+// load returns the stored notes in the order they were added.
+func (s *noteDB) load() ([]storedNote, error) {
+	var notes []storedNote
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(notesBucket).ForEach(func(k, v []byte) error {
+			data, err := s.open(v, k)
+			if err != nil {
+				return fmt.Errorf("note %d: %v", binary.BigEndian.Uint64(k), err)
+			}
+			var n storedNote
+			err = json.Unmarshal(data, &n)
+			clear(data)
+			if err != nil {
+				return err
+			}
+			notes = append(notes, n)
+			return nil
+		})
+	})
+	return notes, err
+}
+
+// storePassphrase reads the passphrase for --store from
+// $QREPH_STORE_PASSPHRASE or, failing that, the terminal.
+func storePassphrase() (string, error) {
+	if p := os.Getenv("QREPH_STORE_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", errors.New("set QREPH_STORE_PASSPHRASE or run on a terminal")
+	}
+	p, err := readPassword("Passphrase for the note store")
+	if err != nil {
+		return "", err
+	}
+	if len(p) == 0 {
+		return "", errors.New("empty passphrase")
+	}
+	return string(p), nil
+}
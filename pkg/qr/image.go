@@ -0,0 +1,56 @@
+package qr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// WriteImage writes the code for text to path, as PNG or SVG depending on
+// the extension.
+func WriteImage(path, text string, level Level) error {
+	code, err := qr.Encode(text, level)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		data = code.PNG()
+	case ".svg":
+		data = []byte(svg(code))
+	default:
+		return fmt.Errorf("%s: unsupported image format, use .png or .svg", path)
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// svg draws code as one path of unit squares inside the four module quiet
+// zone that scanners expect.
+func svg(code *qr.Code) string {
+	var b strings.Builder
+	size := code.Size + 8
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y := range code.Size {
+		for x := range code.Size {
+			if code.Black(x, y) {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+4, y+4)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>` + "\n")
+	return b.String()
+}
+
+// SVG returns the code for text as an SVG image.
+func SVG(text string, level Level) (string, error) {
+	code, err := qr.Encode(text, level)
+	if err != nil {
+		return "", err
+	}
+	return svg(code), nil
+}
@@ -0,0 +1,125 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// Page is one page of a PDF written by WritePDF: a title, the code for
+// Text, and lines of monospaced text below it.
+type Page struct {
+	Title string
+	Text  string
+	Lines []string
+}
+
+// The layout of an A4 page, in points.
+const (
+	pageWidth   = 595
+	pageHeight  = 842
+	pageMargin  = 56
+	pdfCodeSize = 283 // 10 cm
+	lineHeight  = 12
+)
+
+// WritePDF writes pages to path as a PDF for printing. The codes are drawn
+// as vectors, so they stay sharp at any printer resolution. Lines that do
+// not fit go on to pages of their own.
+func WritePDF(path string, pages []Page, level Level) error {
+	var streams []string
+	for _, p := range pages {
+		code, err := qr.Encode(p.Text, level)
+		if err != nil {
+			return err
+		}
+		var b strings.Builder
+		y := pageHeight - pageMargin - 14
+		fmt.Fprintf(&b, "BT /F1 14 Tf %d %d Td (%s) Tj ET\n", pageMargin, y, pdfString(p.Title))
+
+		module := float64(pdfCodeSize) / float64(code.Size+8)
+		left := float64(pageWidth-pdfCodeSize)/2 + 4*module
+		top := float64(y-16) - 4*module
+		b.WriteString("0 g\n")
+		for cy := range code.Size {
+			for cx := range code.Size {
+				if code.Black(cx, cy) {
+					fmt.Fprintf(&b, "%.2f %.2f %.2f %.2f re\n", left+float64(cx)*module, top-float64(cy+1)*module, module, module)
+				}
+			}
+		}
+		b.WriteString("f\n")
+
+		y -= 16 + pdfCodeSize + lineHeight
+		for _, line := range p.Lines {
+			if y < pageMargin {
+				streams = append(streams, b.String())
+				b.Reset()
+				y = pageHeight - pageMargin - 14
+				fmt.Fprintf(&b, "BT /F1 14 Tf %d %d Td (%s) Tj ET\n", pageMargin, y, pdfString(p.Title+" (continued)"))
+				y -= 2 * lineHeight
+			}
+			fmt.Fprintf(&b, "BT /F2 9 Tf %d %d Td (%s) Tj ET\n", pageMargin, y, pdfString(line))
+			y -= lineHeight
+		}
+		streams = append(streams, b.String())
+	}
+
+	// Objects 1 and 2 are the catalog and page tree, 3 and 4 the fonts,
+	// then each page and its content.
+	var objects []string
+	kids := make([]string, len(streams))
+	for i := range streams {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(streams)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+	)
+	for i, s := range streams {
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", pageWidth, pageHeight, 6+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(s), s),
+		)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return os.WriteFile(path, out.Bytes(), 0o600)
+}
+
+// pdfString escapes s for a PDF string literal in WinAnsiEncoding, which
+// matches Latin-1 for letters; anything beyond it becomes ?.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
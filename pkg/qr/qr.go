@@ -0,0 +1,190 @@
+// Package qr draws QR codes on terminals, as block characters or inline
+// images, writes them to PNG, SVG and PDF files and reads them back from
+// images.
+package qr
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/mdp/qrterminal/v3"
+	"golang.org/x/term"
+	"rsc.io/qr"
+)
+
+// Level is the error correction level of a code.
+type Level = qr.Level
+
+const (
+	L = qr.L
+	M = qr.M
+	Q = qr.Q
+	H = qr.H
+)
+
+// Fits reports an error when text is too long for a single code at level.
+func Fits(text string, level Level) error {
+	_, err := qr.Encode(text, level)
+	return err
+}
+
+// Renderer draws codes on a terminal.
+type Renderer struct {
+	// Graphics is the image protocol: sixel, kitty, iterm2, none for text,
+	// or auto to detect it.
+	Graphics string
+	Invert   *bool // swap dark and light text modules, nil to guess from the background
+	Compact  bool  // use half blocks even when the full size code fits
+	Level    Level
+}
+
+// Render draws the QR code for text on w, as an inline image when the
+// terminal supports one of the graphics protocols.
+func (o *Renderer) Render(w *os.File, text string) error {
+	if o.Graphics == "auto" || o.Graphics == "" {
+		o.Graphics = detectGraphics(w)
+	}
+	config := qrterminal.Config{
+		Level:     o.Level,
+		Writer:    w,
+		BlackChar: qrterminal.BLACK,
+		WhiteChar: qrterminal.WHITE,
+		QuietZone: qrterminal.QUIET_ZONE,
+	}
+	if o.Invert == nil {
+		light := lightBackground()
+		o.Invert = &light
+	}
+	switch o.Graphics {
+	case "none":
+		if o.Compact || !fitsTerminal(w, text, config) {
+			config.HalfBlocks = true
+			config.BlackChar, config.WhiteChar = qrterminal.BLACK_BLACK, qrterminal.WHITE_WHITE
+			config.BlackWhiteChar, config.WhiteBlackChar = qrterminal.BLACK_WHITE, qrterminal.WHITE_BLACK
+		}
+		if *o.Invert {
+			config.BlackChar, config.WhiteChar = config.WhiteChar, config.BlackChar
+			config.BlackWhiteChar, config.WhiteBlackChar = config.WhiteBlackChar, config.BlackWhiteChar
+		}
+	case "sixel":
+		config.WithSixel = true
+	case "kitty", "iterm2":
+		code, err := qr.Encode(text, config.Level)
+		if err != nil {
+			return err
+		}
+		png := code.PNG()
+		img := base64.StdEncoding.EncodeToString(png)
+		if o.Graphics == "iterm2" {
+			fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d;preserveAspectRatio=1:%s\a\n", len(png), img)
+			return nil
+		}
+		// kitty wants the payload in chunks of at most 4096 bytes.
+		for first := true; len(img) > 0; first = false {
+			chunk := img[:min(len(img), 4096)]
+			img = img[len(chunk):]
+			more := 0
+			if len(img) > 0 {
+				more = 1
+			}
+			if first {
+				fmt.Fprintf(w, "\x1b_Ga=T,f=100,m=%d;%s\x1b\\", more, chunk)
+			} else {
+				fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+			}
+		}
+		fmt.Fprintln(w)
+		return nil
+	default:
+		return fmt.Errorf("unknown graphics protocol %q", o.Graphics)
+	}
+	qrterminal.GenerateWithConfig(text, config)
+	return nil
+}
+
+// Lines returns the code for text as lines of half block characters, for
+// placing it in a layout. The blocks are the light modules, or the dark ones
+// with Invert, so they take the terminal's foreground color.
+func (o *Renderer) Lines(text string) ([]string, error) {
+	code, err := qr.Encode(text, o.Level)
+	if err != nil {
+		return nil, err
+	}
+	invert := lightBackground()
+	if o.Invert != nil {
+		invert = *o.Invert
+	}
+	// A narrower quiet zone than Render's leaves room next to the code,
+	// phones cope with it.
+	const quiet = 2
+	side := code.Size + 2*quiet
+	drawn := func(x, y int) bool {
+		return y < side && code.Black(x-quiet, y-quiet) == invert
+	}
+	var lines []string
+	for y := 0; y < side; y += 2 {
+		var b strings.Builder
+		for x := 0; x < side; x++ {
+			switch top, bottom := drawn(x, y), drawn(x, y+1); {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		lines = append(lines, b.String())
+	}
+	return lines, nil
+}
+
+// fitsTerminal reports whether the full size text QR code fits the
+// terminal on w, assuming it does when the size is unknown.
+func fitsTerminal(w *os.File, text string, config qrterminal.Config) bool {
+	width, height, err := term.GetSize(int(w.Fd()))
+	if err != nil {
+		return true
+	}
+	code, err := qr.Encode(text, config.Level)
+	if err != nil {
+		return true
+	}
+	side := code.Size + 2*config.QuietZone
+	return 2*side <= width && side < height
+}
+
+// detectGraphics guesses the terminal's image protocol from the environment
+// and falls back to asking it about sixel support.
+func detectGraphics(w *os.File) string {
+	if !term.IsTerminal(int(w.Fd())) {
+		return "none"
+	}
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || os.Getenv("TERM") == "xterm-kitty" || os.Getenv("TERM_PROGRAM") == "ghostty":
+		return "kitty"
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app" || os.Getenv("LC_TERMINAL") == "iTerm2" || os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return "iterm2"
+	case runtime.GOOS == "windows":
+		// The console cannot be read back as the sixel query needs, and the
+		// answer would be left over as input. Text works everywhere there.
+		return "none"
+	case qrterminal.IsSixelSupported(w):
+		return "sixel"
+	}
+	return "none"
+}
+
+// lightBackground reports whether $COLORFGBG, set by rxvt, Konsole and
+// others as "fg;bg", names one of the light ANSI colors as background.
+func lightBackground() bool {
+	fgbg := os.Getenv("COLORFGBG")
+	bg, err := strconv.Atoi(fgbg[strings.LastIndex(fgbg, ";")+1:])
+	return err == nil && (bg == 7 || bg >= 9 && bg <= 15)
+}
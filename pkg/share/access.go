@@ -0,0 +1,117 @@
+package share
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// LevelTrace is the level below Debug at which the access log includes
+// the headers of every request.
+const LevelTrace = slog.LevelDebug - 4
+
+// accessWriter records what went out for the access log.
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// accessLog logs every request to next once it has been answered. A hit is
+// a request for a path mux routes, or without a mux one that did not get a
+// 404. The path is a secret, so it is only logged for misses through mux.
+func accessLog(logger *slog.Logger, mux Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		aw := &accessWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+		peer, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			peer = r.RemoteAddr
+		}
+		hit := aw.status != http.StatusNotFound
+		if mux != nil {
+			_, pattern := mux.Handler(r)
+			hit = pattern != ""
+		}
+		attrs := []any{
+			slog.String("peer", peer),
+			slog.String("method", r.Method),
+			slog.Bool("hit", hit),
+		}
+		if mux != nil && !hit {
+			attrs = append(attrs, slog.String("path", r.URL.Path))
+		}
+		attrs = append(attrs,
+			slog.Int("status", aw.status),
+			slog.Int64("bytes", aw.bytes),
+			slog.String("user_agent", r.UserAgent()),
+		)
+		if logger.Enabled(r.Context(), LevelTrace) {
+			headers := make([]any, 0, len(r.Header))
+			for name, values := range r.Header {
+				headers = append(headers, slog.Any(name, values))
+			}
+			attrs = append(attrs, slog.Group("headers", headers...))
+			logger.Log(r.Context(), LevelTrace, "request", attrs...)
+			return
+		}
+		logger.Info("request", attrs...)
+	})
+}
+
+// logConns logs connections opening and closing at Debug, together with the
+// outcome of TLS handshakes.
+func logConns(logger *slog.Logger) func(net.Conn, http.ConnState) {
+	var handshaken sync.Map // conns whose handshake was logged
+	return func(c net.Conn, state http.ConnState) {
+		if !logger.Enabled(context.Background(), slog.LevelDebug) {
+			return
+		}
+		peer := slog.String("peer", c.RemoteAddr().String())
+		switch state {
+		case http.StateNew:
+			logger.Debug("connection opened", peer)
+		case http.StateActive:
+			tc, ok := c.(*tls.Conn)
+			if _, logged := handshaken.LoadOrStore(c, true); !ok || logged {
+				return
+			}
+			cs := tc.ConnectionState()
+			logger.Debug("tls handshake", peer,
+				slog.String("version", tls.VersionName(cs.Version)),
+				slog.String("cipher", tls.CipherSuiteName(cs.CipherSuite)),
+				slog.String("server_name", cs.ServerName),
+				slog.String("alpn", cs.NegotiatedProtocol),
+				slog.Bool("resumed", cs.DidResume))
+		case http.StateClosed, http.StateHijacked:
+			handshaken.Delete(c)
+			logger.Debug("connection closed", peer)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package share
+
+import (
+	"io"
+
+	"filippo.io/age"
+)
+
+// Age wraps n so it is encrypted to recipients as it is served. The
+// result is always offered as a download since it is opaque to the browser.
+func Age(n *Note, recipients []age.Recipient) *Note {
+	name := n.Filename
+	if name == "" {
+		name = "note.txt"
+	}
+	return &Note{
+		Stream: func(w io.Writer) error {
+			aw, err := age.Encrypt(w, recipients...)
+			if err != nil {
+				return err
+			}
+			if n.Stream != nil {
+				err = n.Stream(aw)
+			} else {
+				_, err = aw.Write(n.Content)
+			}
+			if err != nil {
+				return err
+			}
+			return aw.Close()
+		},
+		ContentType: "application/octet-stream",
+		Filename:    name + ".age",
+		wraps:       n,
+	}
+}
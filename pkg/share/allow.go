@@ -0,0 +1,82 @@
+package share
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseNet reads a network in CIDR notation, such as 192.168.1.0/24, or a
+// single address.
+func ParseNet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q, want e.g. 192.168.1.0/24", s)
+		}
+		return n, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid network %q, want e.g. 192.168.1.0/24", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// peerIP returns the address r came from, or nil if it has none, as over
+// a unix socket.
+func peerIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// allowPeers answers requests from outside nets with a 404, as if there
+// was nothing at the path.
+func allowPeers(nets []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := peerIP(r); ip != nil {
+			for _, n := range nets {
+				if n.Contains(ip) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		http.NotFound(w, r)
+	})
+}
+
+// localNets returns the subnets the interfaces holding addrs are on.
+func localNets(addrs []*net.IPAddr) ([]*net.IPNet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var nets []*net.IPNet
+	for _, addr := range addrs {
+		found := false
+		for _, iface := range ifaces {
+			ifAddrs, err := iface.Addrs()
+			if err != nil {
+				continue
+			}
+			for _, a := range ifAddrs {
+				if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(addr.IP) {
+					nets = append(nets, &net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask})
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no local interface has the address %s", addr.IP)
+		}
+	}
+	return nets, nil
+}
@@ -0,0 +1,240 @@
+package share
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// The landing page is served to browsers when TLS is on. It first asks
+// for the server's key exchange key, signed with the certificate key, and
+// checks the certificate's public key against the hash in the URL fragment.
+// Only once both hold does it ask for the note, encrypted to its own
+// ephemeral ECDH key, so a server that does not match never gets to use the
+// note up and a proxy that terminates TLS but passes the page on unchanged
+// ends up with ciphertext. The page comes over the same connection, so this
+// does not help against one that rewrites it.
+const pinnedPage = cryptoPageHead + `
+		var pin = unb64url(location.hash.slice(1));
+		function post(body) {
+			return fetch(location.pathname, {
+				method: "POST",
+				headers: {"Content-Type": "application/json"},
+				body: JSON.stringify(body)
+			});
+		}
+		var resp = await post({});
+		if (!resp.ok) throw new Error("This note is gone.");
+		var hello = await resp.json();
+		var spki = unb64(hello.spki);
+		if (!equal(new Uint8Array(await crypto.subtle.digest("SHA-256", spki)), pin)) {
+			throw new Error("The certificate does not match the QR code. The connection may be intercepted.");
+		}
+		var serverPub = unb64(hello.pub);
+		var verifyKey = await crypto.subtle.importKey("spki", spki, {name: "ECDSA", namedCurve: "P-256"}, false, ["verify"]);
+		if (!await crypto.subtle.verify({name: "ECDSA", hash: "SHA-256"}, verifyKey, unb64(hello.sig), serverPub)) {
+			throw new Error("The key exchange signature is invalid. The connection may be intercepted.");
+		}
+		var kp = await crypto.subtle.generateKey({name: "ECDH", namedCurve: "P-256"}, false, ["deriveBits"]);
+		var pub = new Uint8Array(await crypto.subtle.exportKey("raw", kp.publicKey));
+		resp = await post({key: b64(pub)});
+		if (!resp.ok) throw new Error("This note is gone.");
+		var msg = await resp.json();
+		var peer = await crypto.subtle.importKey("raw", serverPub, {name: "ECDH", namedCurve: "P-256"}, false, []);
+		var secret = await crypto.subtle.deriveBits({name: "ECDH", public: peer}, kp.privateKey, 256);
+		var hk = await crypto.subtle.importKey("raw", secret, "HKDF", false, ["deriveKey"]);
+		var key = await crypto.subtle.deriveKey(
+			{name: "HKDF", hash: "SHA-256", salt: new Uint8Array(), info: new TextEncoder().encode("qreph")},
+			hk, {name: "AES-GCM", length: 256}, false, ["decrypt"]);
+		var data = await crypto.subtle.decrypt({name: "AES-GCM", iv: unb64(msg.iv)}, key, unb64(msg.data));
+		reveal(data, msg.type, msg.name);
+` + cryptoPageFoot
+
+// A pinnedRequest without a key asks for the pinnedHello, one with a key
+// for the note.
+type pinnedRequest struct {
+	Key []byte `json:"key"`
+}
+
+// pinnedHello proves the exchange key Pub belongs to the certificate
+// whose public key is SPKI.
+type pinnedHello struct {
+	SPKI []byte `json:"spki"`
+	Pub  []byte `json:"pub"`
+	Sig  []byte `json:"sig"`
+}
+
+type pinnedResponse struct {
+	IV   []byte `json:"iv"`
+	Data []byte `json:"data"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// pinnedExchange is the server side of the key exchange, one per handler.
+type pinnedExchange struct {
+	priv  *ecdh.PrivateKey
+	hello pinnedHello
+}
+
+func newPinnedExchange(cert *tls.Certificate) (*pinnedExchange, error) {
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	pub := priv.PublicKey().Bytes()
+	sig, err := signP1363(cert, pub)
+	if err != nil {
+		return nil, err
+	}
+	return &pinnedExchange{priv: priv, hello: pinnedHello{SPKI: spki(cert), Pub: pub, Sig: sig}}, nil
+}
+
+func spki(cert *tls.Certificate) []byte {
+	key := cert.PrivateKey.(*ecdsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		panic(err)
+	}
+	return der
+}
+
+// SPKIPin is the hash of cert's public key that goes in the URL fragment
+// for PinnedHandler.
+func SPKIPin(cert *tls.Certificate) string {
+	sum := sha256.Sum256(spki(cert))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// PinnedHandler serves browsers a page that checks the server key against
+// the URL fragment and then fetches the note over an exchange signed by
+// cert. Other clients go to next.
+func PinnedHandler(store *Store, cert *tls.Certificate, next http.Handler, done chan struct{}) http.HandlerFunc {
+	exchange := sync.OnceValues(func() (*pinnedExchange, error) { return newPinnedExchange(cert) })
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			var req pinnedRequest
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&req); err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			ex, err := exchange()
+			if err != nil {
+				store.logger.Printf("failed to set up the key exchange: %v", err)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			if req.Key == nil {
+				// Looking before fetching, that does not count.
+				if store.Peek() == nil {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(ex.hello)
+				return
+			}
+			clientKey, err := ecdh.P256().NewPublicKey(req.Key)
+			if err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			n, last := store.Get()
+			if n == nil {
+				http.NotFound(w, r)
+				return
+			}
+			resp, err := sealNote(n, ex.priv, clientKey)
+			if err != nil {
+				store.logger.Printf("failed to encrypt note, it is still available: %v", err)
+				store.Release(n)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			dw := &deliveredWriter{ResponseWriter: w}
+			dw.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(dw).Encode(resp); err != nil {
+				store.logger.Printf("failed to deliver note, it is still available: %v", err)
+				store.Release(n)
+				return
+			}
+			store.report(r, dw.written)
+			if last {
+				close(done)
+			}
+		case strings.Contains(r.Header.Get("Accept"), "text/html"):
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			io.WriteString(w, pinnedPage)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+func sealNote(n *Note, priv *ecdh.PrivateKey, clientKey *ecdh.PublicKey) (*pinnedResponse, error) {
+	content, err := n.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	defer n.clearCopy(content)
+
+	secret, err := priv.ECDH(clientKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hkdf.Key(sha256.New, secret, nil, "qreph", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	return &pinnedResponse{
+		IV:   iv,
+		Data: gcm.Seal(nil, iv, content, nil),
+		Type: n.ContentType,
+		Name: n.Filename,
+	}, nil
+}
+
+// signP1363 signs msg in the fixed-width r||s encoding WebCrypto expects.
+func signP1363(cert *tls.Certificate, msg []byte) ([]byte, error) {
+	key, ok := cert.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("certificate key is not ECDSA")
+	}
+	digest := sha256.Sum256(msg)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return sig, nil
+}
@@ -0,0 +1,146 @@
+package share
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func pinnedPost(h http.Handler, body any) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", bytes.NewReader(data)))
+	return w
+}
+
+func testCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := selfSignedCert(key, []net.IP{net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// The exchange as the page does it: check the key, then fetch.
+func TestPinnedHandler(t *testing.T) {
+	cert := testCert(t)
+	store := NewStore(&Note{Content: []byte("hello"), ContentType: "text/plain"}, quiet)
+	done := make(chan struct{})
+	h := PinnedHandler(store, &cert, http.NotFoundHandler(), done)
+
+	var hello pinnedHello
+	for range 2 {
+		w := pinnedPost(h, map[string]any{})
+		if w.Code != http.StatusOK {
+			t.Fatalf("hello: %d", w.Code)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &hello); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.Peek() == nil || closed(done) {
+		t.Fatal("asking for the exchange key used up the note")
+	}
+	sum := sha256.Sum256(hello.SPKI)
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != SPKIPin(&cert) {
+		t.Fatal("SPKI does not match the pin")
+	}
+	pub, err := x509.ParsePKIXPublicKey(hello.SPKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(hello.Pub)
+	r, s := new(big.Int).SetBytes(hello.Sig[:32]), new(big.Int).SetBytes(hello.Sig[32:])
+	if !ecdsa.Verify(pub.(*ecdsa.PublicKey), digest[:], r, s) {
+		t.Fatal("bad exchange key signature")
+	}
+
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := pinnedPost(h, pinnedRequest{Key: priv.PublicKey().Bytes()})
+	if w.Code != http.StatusOK {
+		t.Fatalf("note: %d %s", w.Code, w.Body)
+	}
+	var resp pinnedResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	serverPub, err := ecdh.P256().NewPublicKey(hello.Pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := priv.ECDH(serverPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesKey, err := hkdf.Key(sha256.New, secret, nil, "qreph", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(aesKey)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, resp.IV, resp.Data, nil)
+	if err != nil || string(plain) != "hello" || resp.Type != "text/plain" {
+		t.Fatalf("got %q, %q, %v", plain, resp.Type, err)
+	}
+	if !closed(done) {
+		t.Fatal("done not closed")
+	}
+	if w := pinnedPost(h, map[string]any{}); w.Code != http.StatusNotFound {
+		t.Fatalf("hello after the fetch: %d", w.Code)
+	}
+}
+
+// A note that cannot be sealed stays for the next try.
+func TestPinnedHandlerFailure(t *testing.T) {
+	cert := testCert(t)
+	n := &Note{Stream: func(io.Writer) error { return errors.New("disk gone") }}
+	store := NewStore(n, quiet)
+	done := make(chan struct{})
+	h := PinnedHandler(store, &cert, http.NotFoundHandler(), done)
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := pinnedPost(h, pinnedRequest{Key: priv.PublicKey().Bytes()}); w.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d", w.Code)
+	}
+	if store.Peek() != n || closed(done) {
+		t.Fatal("a failed delivery used up the note")
+	}
+}
+
+func TestPinnedHandlerPage(t *testing.T) {
+	h := PinnedHandler(NewStore(&Note{Content: []byte("hello")}, quiet), nil, http.NotFoundHandler(), make(chan struct{}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "crypto.subtle") {
+		t.Fatalf("got %d", w.Code)
+	}
+}
@@ -0,0 +1,87 @@
+package share
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Text smaller than this goes out as is, compressing it saves nothing
+// worth the CPU.
+const compressMin = 1 << 10
+
+// compressible reports whether n is text that is worth compressing.
+func compressible(n *Note) bool {
+	if len(n.Content) < compressMin {
+		return false
+	}
+	typ, _, _ := mime.ParseMediaType(n.ContentType)
+	switch {
+	case strings.HasPrefix(typ, "text/"),
+		typ == "application/json", typ == "application/xml", typ == "application/javascript",
+		strings.HasSuffix(typ, "+json"), strings.HasSuffix(typ, "+xml"):
+		return true
+	}
+	return false
+}
+
+// negotiateEncoding picks zstd or gzip from Accept-Encoding, or "" when the
+// client accepts neither.
+func negotiateEncoding(r *http.Request) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[strings.ToLower(name)] = weight
+	}
+	for _, enc := range []string{"zstd", "gzip"} {
+		if w, ok := q[enc]; ok && w > 0 {
+			return enc
+		}
+		if w, ok := q["*"]; ok && w > 0 {
+			if _, named := q[enc]; !named {
+				return enc
+			}
+		}
+	}
+	return ""
+}
+
+// writeCompressed sends the whole note with encoding enc. It is compressed
+// into a buffer that is wiped afterwards, which also gives the response a
+// length.
+func writeCompressed(w http.ResponseWriter, n *Note, enc string) error {
+	var buf bytes.Buffer
+	defer func() { clear(buf.Bytes()) }()
+	var zw io.WriteCloser
+	if enc == "zstd" {
+		var err error
+		if zw, err = zstd.NewWriter(&buf); err != nil {
+			return err
+		}
+	} else {
+		zw = gzip.NewWriter(&buf)
+	}
+	if _, err := zw.Write(n.Content); err != nil {
+		zw.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Encoding", enc)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,70 @@
+package share
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Link previews in chat apps and mail scanners fetch URLs as soon as they are
+// shared. Browsers get this page instead of the note and only a click on its
+// button, which previewers never do, releases it.
+const confirmPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>qreph</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+button { font-size: 1.5em; width: 100%; }
+</style>
+</head>
+<body>
+<form method="post">
+<p>This note can only be opened once.</p>
+<button type="submit">Show note</button>
+</form>
+</body>
+</html>
+`
+
+// ConfirmHandler makes browsers click through confirmPage before next
+// serves them.
+func ConfirmHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			io.WriteString(w, confirmPage)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// previewBots are User-Agent fragments of crawlers and link unfurlers, which
+// are turned away before they can touch the note.
+var previewBots = []string{
+	"slackbot", "slack-imgproxy", "facebookexternalhit", "facebookcatalog", "whatsapp",
+	"telegrambot", "twitterbot", "discordbot", "linkedinbot", "skypeuripreview",
+	"microsoftpreview", "ms-office", "microsoft office", "bingpreview", "bingbot", "googlebot",
+	"google-inspectiontool", "applebot", "redditbot", "pinterest", "embedly", "iframely",
+	"vkshare", "viber", "mattermost", "yandex", "duckduckbot", "baiduspider",
+}
+
+// BotFilter answers requests from previewBots and extra with 404.
+func BotFilter(extra []string, next http.Handler) http.HandlerFunc {
+	bots := append(append([]string(nil), previewBots...), extra...)
+	return func(w http.ResponseWriter, r *http.Request) {
+		ua := strings.ToLower(r.UserAgent())
+		for _, bot := range bots {
+			if bot != "" && strings.Contains(ua, strings.ToLower(bot)) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,103 @@
+package share
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// The page reads the key from the URL fragment, which browsers never send to
+// the server, and decrypts the note locally.
+const e2ePage = cryptoPageHead + `
+		var key = await crypto.subtle.importKey("raw", unb64url(location.hash.replace(/^#(e2e-)?/, "")), "AES-GCM", false, ["decrypt"]);
+		var resp = await fetch(location.pathname, {headers: {"Accept": "application/octet-stream"}});
+		if (!resp.ok) throw new Error("This note is gone.");
+		var body = new Uint8Array(await resp.arrayBuffer());
+		var plain = await crypto.subtle.decrypt({name: "AES-GCM", iv: body.slice(0, 12)}, key, body.slice(12));
+		var msg = JSON.parse(new TextDecoder().decode(plain));
+		reveal(unb64(msg.data), msg.type, msg.name);
+` + cryptoPageFoot
+
+// e2eFragment starts the URL fragment of SealE2E notes, so clients can tell
+// the key from a certificate pin before they connect.
+const e2eFragment = "e2e-"
+
+// SealE2E encrypts n under a fresh key and returns the ciphertext as a new
+// note along with the key encoded for the URL fragment.
+func SealE2E(n *Note) (*Note, string, error) {
+	plaintext, err := n.MarshalPayload()
+	if err != nil {
+		return nil, "", err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", err
+	}
+	sealed := &Note{
+		Content:     gcm.Seal(nonce, nonce, plaintext, nil),
+		ContentType: "application/octet-stream",
+		wraps:       n,
+	}
+	clear(plaintext)
+	return sealed, e2eFragment + base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// OpenE2E reverses SealE2E for clients other than the browser page.
+func OpenE2E(ciphertext []byte, key string) (*Payload, error) {
+	k, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(key, e2eFragment))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("short ciphertext")
+	}
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+	var p Payload
+	if err := json.Unmarshal(plaintext, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// E2EHandler serves browsers the page that decrypts a SealE2E note with
+// the key from the URL fragment. Other clients go to next.
+func E2EHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			io.WriteString(w, e2ePage)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
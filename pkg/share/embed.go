@@ -0,0 +1,41 @@
+package share
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// NewHandler serves content at the random path it returns, for mounting in
+// an existing server. done is closed after the last fetch, or when
+// WithTTL burns the note, and content is overwritten with zeros then.
+func NewHandler(content []byte, opts ...Option) (h http.Handler, path string, done <-chan struct{}) {
+	c := newConfig(opts)
+	n := &Note{Content: content, ContentType: c.contentType, Filename: c.filename}
+	if n.ContentType == "" {
+		n.ContentType = DetectContentType(content)
+	}
+	sum := sha256.Sum256(content)
+	n.Sum = hex.EncodeToString(sum[:])
+	n.View, n.Lang = DetectView(n)
+
+	path, _ = RandomPath()
+	closed := make(chan struct{})
+	store := NewStore(n, opts...)
+	if c.ttl > 0 {
+		time.AfterFunc(c.ttl, func() {
+			if store.Burn() {
+				store.logger.Printf("expired after %s, note destroyed", c.ttl)
+				close(closed)
+			}
+		})
+	}
+	go func() {
+		<-closed
+		store.Burn()
+	}()
+	mux := http.NewServeMux()
+	mux.Handle(path, NoteHandler(store, closed))
+	return mux, path, closed
+}
@@ -0,0 +1,78 @@
+package share
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Fetch downloads a note served by qreph. A URL fragment is either the
+// hash of the server's key, which then replaces certificate checks, or the
+// end-to-end key, which makes them unnecessary.
+func Fetch(raw string) (*Payload, http.Header, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := u.Fragment
+	u.Fragment = ""
+	e2e := strings.HasPrefix(key, e2eFragment)
+
+	client := http.DefaultClient
+	switch {
+	case e2e:
+		// Whoever answers only gets ciphertext.
+		client = &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}}
+	case key != "":
+		if u.Scheme != "https" {
+			return nil, nil, errors.New("the URL carries a certificate pin but is not https")
+		}
+		// The pin is checked in the handshake, so a server that does not
+		// match never sees the request and cannot use up the note.
+		client = &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				VerifyConnection: func(cs tls.ConnectionState) error {
+					sum := sha256.Sum256(cs.PeerCertificates[0].RawSubjectPublicKeyInfo)
+					if base64.RawURLEncoding.EncodeToString(sum[:]) != key {
+						return errors.New("the server does not match the QR code")
+					}
+					return nil
+				},
+			},
+		}}
+	}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, errors.New(resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if e2e {
+		p, err := OpenE2E(body, key)
+		if err != nil {
+			return nil, nil, errors.New("the note is not end-to-end encrypted to the key in the QR code")
+		}
+		return p, resp.Header, nil
+	}
+	p := &Payload{Type: resp.Header.Get("Content-Type"), Data: body}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		p.Name = params["filename"]
+	}
+	return p, resp.Header, nil
+}
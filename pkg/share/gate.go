@@ -0,0 +1,138 @@
+package share
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"sync"
+)
+
+const maxPINAttempts = 5
+
+var gatePage = template.Must(template.New("gate").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>qreph</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+input, button { font-size: 1.5em; width: 100%; margin-top: 0.5em; }
+</style>
+</head>
+<body>
+<form method="post">
+<label for="pin">Enter the PIN shown on the sender's screen</label>
+<input id="pin" name="pin" inputmode="numeric" autocomplete="off" autofocus>
+<button type="submit">Open</button>
+{{if .}}<p>{{.}}</p>{{end}}
+</form>
+<script>
+// The fragment may hold the note's key, so it stays in the browser; the
+// note page puts it back after the redirect.
+document.querySelector("form").addEventListener("submit", function () {
+	sessionStorage.setItem("qreph-hash", location.hash);
+});
+</script>
+</body>
+</html>
+`))
+
+// PINGate holds back a note until the receiver enters a random PIN.
+type PINGate struct {
+	pin      string
+	session  string
+	mu       sync.Mutex
+	failures int
+}
+
+// NewPINGate picks a fresh six digit PIN.
+func NewPINGate() (*PINGate, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return nil, err
+	}
+	session := make([]byte, 32)
+	if _, err := rand.Read(session); err != nil {
+		return nil, err
+	}
+	return &PINGate{
+		pin:     fmt.Sprintf("%06d", n.Int64()),
+		session: base64.RawURLEncoding.EncodeToString(session),
+	}, nil
+}
+
+// PIN returns the PIN to tell the receiver.
+func (g *PINGate) PIN() string {
+	return g.pin
+}
+
+func (g *PINGate) check(pin string) (ok, exhausted bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.failures >= maxPINAttempts {
+		return false, true
+	}
+	if subtle.ConstantTimeCompare([]byte(pin), []byte(g.pin)) == 1 {
+		return true, false
+	}
+	g.failures++
+	return false, g.failures >= maxPINAttempts
+}
+
+func (g *PINGate) unlocked(r *http.Request) bool {
+	c, err := r.Cookie("qreph")
+	return err == nil && subtle.ConstantTimeCompare([]byte(c.Value), []byte(g.session)) == 1
+}
+
+// Handler releases the note only to requests that carry the PIN in the
+// X-Qreph-Pin header or the session cookie set after a correct form entry.
+// Too many wrong guesses destroy the note.
+func (g *PINGate) Handler(store *Store, next http.Handler, done chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g.unlocked(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		pin := r.Header.Get("X-Qreph-Pin")
+		if pin == "" && r.Method == http.MethodPost {
+			pin = r.PostFormValue("pin")
+		}
+		if pin == "" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			gatePage.Execute(w, "")
+			return
+		}
+
+		ok, exhausted := g.check(pin)
+		switch {
+		case ok && r.Header.Get("X-Qreph-Pin") != "":
+			next.ServeHTTP(w, r)
+		case ok:
+			http.SetCookie(w, &http.Cookie{
+				Name:     "qreph",
+				Value:    g.session,
+				Path:     requestPath(r),
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, requestPath(r), http.StatusSeeOther)
+		case exhausted:
+			if store.Burn() {
+				store.logger.Print("too many wrong PIN attempts, note destroyed")
+				close(done)
+			}
+			http.NotFound(w, r)
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusForbidden)
+			gatePage.Execute(w, "Wrong PIN.")
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package share
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// banDuration is how long a peer guessing paths is shut out.
+const banDuration = 10 * time.Minute
+
+// peerGuard limits how often each peer may ask, and bans peers that keep
+// asking for paths that are not there, which is what guessing the secret
+// path looks like.
+type peerGuard struct {
+	perMinute int // 0 for no limit
+	banAfter  int // 0 to never ban
+
+	mu    sync.Mutex
+	peers map[string]*peerState
+}
+
+type peerState struct {
+	tokens float64
+	last   time.Time
+	misses int
+	banned time.Time // until then
+}
+
+// peerKey groups IPv6 peers by /64, which one machine can rotate through.
+func peerKey(ip net.IP) string {
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return ip.String()
+}
+
+// admit reports whether the peer may make a request now, and if not, how
+// long to wait.
+func (g *peerGuard) admit(key string) (ok bool, retry time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	p := g.peers[key]
+	if p == nil {
+		if len(g.peers) >= 4096 {
+			g.prune(now)
+		}
+		p = &peerState{tokens: float64(g.perMinute), last: now}
+		g.peers[key] = p
+	}
+	if now.Before(p.banned) {
+		return false, p.banned.Sub(now)
+	}
+	if g.perMinute > 0 {
+		p.tokens = min(float64(g.perMinute), p.tokens+now.Sub(p.last).Minutes()*float64(g.perMinute))
+		p.last = now
+		if p.tokens < 1 {
+			return false, time.Duration((1 - p.tokens) / float64(g.perMinute) * float64(time.Minute))
+		}
+		p.tokens--
+	}
+	p.last = now
+	return true, 0
+}
+
+// result counts a request that found its path or not, and reports whether
+// that got the peer banned.
+func (g *peerGuard) result(key string, hit bool) (banned bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p := g.peers[key]
+	if p == nil {
+		return false
+	}
+	if hit {
+		p.misses = 0
+		return false
+	}
+	p.misses++
+	if g.banAfter > 0 && p.misses >= g.banAfter {
+		p.misses = 0
+		p.banned = time.Now().Add(banDuration)
+		return true
+	}
+	return false
+}
+
+// prune forgets peers that have been quiet for a while and are not
+// banned.
+func (g *peerGuard) prune(now time.Time) {
+	for key, p := range g.peers {
+		if now.After(p.banned) && now.Sub(p.last) > banDuration {
+			delete(g.peers, key)
+		}
+	}
+}
+
+// guardPeers answers requests the guard turns away with 429. A miss is a
+// request for a path mux does not route, or without a mux one that got a
+// 404.
+func (s *Server) guardPeers(g *peerGuard, mux Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := peerIP(r)
+		if ip == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := peerKey(ip)
+		if ok, retry := g.admit(key); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		aw := &accessWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+		hit := aw.status != http.StatusNotFound
+		if mux != nil {
+			_, pattern := mux.Handler(r)
+			hit = pattern != ""
+		}
+		if g.result(key, hit) {
+			s.opts.logger.Printf("banned %s for %s after %d requests for unknown paths", key, banDuration, g.banAfter)
+		}
+	})
+}
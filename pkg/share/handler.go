@@ -0,0 +1,101 @@
+package share
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// SignatureHeader carries Note.Sig.
+const SignatureHeader = "X-Content-Signature"
+
+// NoteHandler serves the note in store to GET and POST requests and closes
+// done once it has been fetched for the last time.
+func NoteHandler(store *Store, done chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodPost:
+		case http.MethodHead:
+			// Some clients look before they fetch, that does not count.
+			n := store.Peek()
+			if n == nil {
+				http.NotFound(w, r)
+				return
+			}
+			if n.Stream == nil {
+				serveContent(w, r, store, done)
+				return
+			}
+			setNoteHeaders(w, n)
+			return
+		case http.MethodOptions:
+			w.Header().Set("Allow", "GET, HEAD, POST, OPTIONS")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST, OPTIONS")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if n := store.Peek(); n != nil && n.Stream == nil {
+			serveContent(w, r, store, done)
+			return
+		}
+		n, last := store.Get()
+		if n == nil {
+			http.NotFound(w, r)
+			return
+		}
+		dw := &deliveredWriter{ResponseWriter: w}
+		var err error
+		if n.View != "" && wantsHTML(r) {
+			err = writeView(dw, n)
+		} else {
+			setNoteHeaders(dw, n)
+			err = n.Stream(dw)
+		}
+		if err != nil {
+			store.logger.Printf("failed to deliver note, it is still available: %v", err)
+			store.Release(n)
+			return
+		}
+		store.report(r, dw.written)
+		if last {
+			close(done)
+		}
+	}
+}
+
+func writeView(w http.ResponseWriter, n *Note) error {
+	content, err := n.Bytes()
+	if err != nil {
+		return err
+	}
+	defer n.clearCopy(content)
+	page, err := renderView(n, content)
+	if err != nil {
+		return err
+	}
+	defer clear(page)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err = w.Write(page)
+	return err
+}
+
+func setNoteHeaders(w http.ResponseWriter, n *Note) {
+	w.Header().Set("Content-Type", n.ContentType)
+	if n.Sum != "" {
+		w.Header().Set("X-Content-SHA256", n.Sum)
+	}
+	if n.Sig != "" {
+		w.Header().Set(SignatureHeader, n.Sig)
+	}
+	if n.Filename != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": n.Filename}))
+	}
+	if n.Stream == nil {
+		w.Header().Set("Content-Length", strconv.Itoa(len(n.Content)))
+	}
+}
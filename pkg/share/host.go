@@ -0,0 +1,43 @@
+package share
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// hostName reduces a Host header or URL host to the bare lower case name
+// or address, without port, brackets or IPv6 zone.
+func hostName(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	host, _, _ = strings.Cut(host, "%")
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// hosts returns the names the server is advertised under.
+func (s *Server) hosts() map[string]bool {
+	hosts := map[string]bool{}
+	for _, e := range s.endpoints {
+		hosts[hostName(e.host)] = true
+	}
+	if u, err := url.Parse(s.opts.publicURL); err == nil && u.Host != "" {
+		hosts[hostName(u.Host)] = true
+	}
+	return hosts
+}
+
+// checkHost refuses requests for a host the server is not advertised
+// under, as a web page on a DNS name rebound to this machine would make.
+func checkHost(hosts map[string]bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hosts[hostName(r.Host)] {
+			http.Error(w, "unknown host", http.StatusMisdirectedRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,53 @@
+package share
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// HTTP/3 runs on the UDP port with the number of the TCP one. Clients only
+// learn about it from the Alt-Svc header of a response over TCP, so it is
+// used from the second request on, such as the fetch behind a landing page.
+
+func (s *Server) listenHTTP3() error {
+	if s.cert == nil {
+		return errors.New("HTTP/3 needs TLS")
+	}
+	addr, ok := s.listener.Addr().(*net.TCPAddr)
+	if !ok || s.opts.wan || s.opts.tailscale || s.opts.publicURL != "" {
+		return errors.New("HTTP/3 only works on the advertised local port")
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone})
+	if err != nil {
+		return fmt.Errorf("failed to listen for HTTP/3: %w", err)
+	}
+	s.h3Conn = conn
+	s.h3 = &http3.Server{
+		TLSConfig: s.tlsConfig,
+		Port:      addr.Port,
+	}
+	s.cleanup = append(s.cleanup, func() {
+		s.h3.Close()
+		conn.Close()
+	})
+	return nil
+}
+
+// serveHTTP3 answers HTTP/3 requests with handler and returns the handler
+// for TCP, which points clients at HTTP/3.
+func (s *Server) serveHTTP3(handler http.Handler) http.Handler {
+	s.h3.Handler = handler
+	go func() {
+		if err := s.h3.Serve(s.h3Conn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.opts.logger.Printf("http3: %v", err)
+		}
+	}()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.h3.SetQUICHeaders(w.Header())
+		handler.ServeHTTP(w, r)
+	})
+}
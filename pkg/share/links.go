@@ -0,0 +1,114 @@
+package share
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// linkSigner puts an expiry time and an HMAC of it and the path at the end
+// of the paths in URLs, so that a URL stops working at that time while the
+// note is still served and old screenshots of the QR code cannot be
+// replayed: /path/expiry.mac.
+type linkSigner struct {
+	key []byte
+	ttl time.Duration
+}
+
+func (l *linkSigner) mac(path string, expiry int64) string {
+	h := hmac.New(sha256.New, l.key)
+	fmt.Fprintf(h, "%s\n%d", path, expiry)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
+}
+
+func (l *linkSigner) sign(path string) string {
+	expiry := time.Now().Add(l.ttl).Unix()
+	return fmt.Sprintf("%s/%d.%s", path, expiry, l.mac(path, expiry))
+}
+
+// verify returns the path signed in signed and 200 if the signature holds,
+// 410 if it has expired and 404 if it does not hold.
+func (l *linkSigner) verify(signed string) (path string, status int) {
+	i := strings.LastIndexByte(signed, '/')
+	if i <= 0 {
+		return "", http.StatusNotFound
+	}
+	path = signed[:i]
+	expiryText, mac, _ := strings.Cut(signed[i+1:], ".")
+	expiry, err := strconv.ParseInt(expiryText, 10, 64)
+	if err != nil || !hmac.Equal([]byte(mac), []byte(l.mac(path, expiry))) {
+		return "", http.StatusNotFound
+	}
+	if time.Now().Unix() >= expiry {
+		return path, http.StatusGone
+	}
+	return path, http.StatusOK
+}
+
+// linkRouter passes requests with a good signature on to next with it
+// taken off the path. It is a Router, so the access log and the peer guard
+// count expired and forged links as misses.
+type linkRouter struct {
+	signer *linkSigner
+	next   http.Handler
+	mux    Router // next, if it is one
+}
+
+type signedPathKey struct{}
+
+func (l *linkRouter) strip(r *http.Request) (*http.Request, int) {
+	path, status := l.signer.verify(r.URL.Path)
+	if status != http.StatusOK {
+		return nil, status
+	}
+	r = r.Clone(context.WithValue(r.Context(), signedPathKey{}, r.URL.Path))
+	r.URL.Path, r.URL.RawPath = path, ""
+	return r, status
+}
+
+// requestPath returns the path r was made for, with the signature that
+// linkRouter takes off, for redirects and cookies.
+func requestPath(r *http.Request) string {
+	if path, ok := r.Context().Value(signedPathKey{}).(string); ok {
+		return path
+	}
+	return r.URL.Path
+}
+
+func (l *linkRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stripped, status := l.strip(r)
+	switch status {
+	case http.StatusOK:
+		l.next.ServeHTTP(w, stripped)
+	case http.StatusGone:
+		http.Error(w, "this link has expired, ask the sender for a new one", http.StatusGone)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (l *linkRouter) Handler(r *http.Request) (http.Handler, string) {
+	stripped, status := l.strip(r)
+	switch {
+	case status != http.StatusOK:
+		return l, ""
+	case l.mux != nil:
+		return l.mux.Handler(stripped)
+	}
+	return l.next, stripped.URL.Path
+}
+
+// SignPath returns path as it goes in URLs: with WithLinkExpiry, signed
+// with an expiry time, otherwise as it is.
+func (s *Server) SignPath(path string) string {
+	if s.links == nil {
+		return path
+	}
+	return s.links.sign(path)
+}
@@ -0,0 +1,100 @@
+package share
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLinkSigner(t *testing.T) {
+	l := &linkSigner{key: []byte("key"), ttl: time.Hour}
+	signed := l.sign("/note")
+	expiry, mac, _ := strings.Cut(strings.TrimPrefix(signed, "/note/"), ".")
+	expired := (&linkSigner{key: l.key, ttl: -time.Minute}).sign("/note")
+	tests := []struct {
+		name   string
+		signed string
+		path   string
+		status int
+	}{
+		{"signed", signed, "/note", http.StatusOK},
+		{"expired", expired, "/note", http.StatusGone},
+		{"other key", (&linkSigner{key: []byte("other"), ttl: time.Hour}).sign("/note"), "", http.StatusNotFound},
+		{"other path", "/other/" + expiry + "." + mac, "", http.StatusNotFound},
+		{"later expiry", "/note/" + expiry + "1." + mac, "", http.StatusNotFound},
+		{"no mac", "/note/" + expiry, "", http.StatusNotFound},
+		{"unsigned", "/note", "", http.StatusNotFound},
+		{"empty", "", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, status := l.verify(tt.signed)
+			if path != tt.path || status != tt.status {
+				t.Fatalf("verify(%q) = %q, %d, want %q, %d", tt.signed, path, status, tt.path, tt.status)
+			}
+		})
+	}
+}
+
+func TestLinkRouter(t *testing.T) {
+	l := &linkSigner{key: []byte("key"), ttl: time.Hour}
+	var path, signedPath string
+	router := &linkRouter{signer: l, next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, signedPath = r.URL.Path, requestPath(r)
+	})}
+	signed := l.sign("/note")
+	tests := []struct {
+		name   string
+		target string
+		status int
+		path   string
+	}{
+		{"signed", signed, http.StatusOK, "/note"},
+		{"expired", (&linkSigner{key: l.key, ttl: -time.Minute}).sign("/note"), http.StatusGone, ""},
+		{"unsigned", "/note", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, signedPath = "", ""
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+			if w.Code != tt.status || path != tt.path {
+				t.Fatalf("got %d for %q, want %d for %q", w.Code, path, tt.status, tt.path)
+			}
+			if tt.status == http.StatusOK && signedPath != tt.target {
+				t.Fatalf("requestPath = %q, want %q", signedPath, tt.target)
+			}
+		})
+	}
+}
+
+// The PIN form sends the receiver back to the URL they opened, signature and
+// all, and the cookie must be sent there. The fragment never comes along.
+func TestPINGateSignedLink(t *testing.T) {
+	l := &linkSigner{key: []byte("key"), ttl: time.Hour}
+	g, err := NewPINGate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := &linkRouter{signer: l, next: g.Handler(nil, http.NotFoundHandler(), nil)}
+	signed := l.sign("/note")
+	r := httptest.NewRequest("POST", signed, strings.NewReader("pin="+g.PIN()+"&hash=key"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if got := w.Header().Get("Location"); w.Code != http.StatusSeeOther || got != signed {
+		t.Fatalf("got %d to %q, want %d to %q", w.Code, got, http.StatusSeeOther, signed)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Path != signed {
+		t.Fatalf("cookies = %v, want one for %q", cookies, signed)
+	}
+}
+
+func TestRequestPathUnsigned(t *testing.T) {
+	if got := requestPath(httptest.NewRequest("GET", "/note", nil)); got != "/note" {
+		t.Fatalf("requestPath = %q", got)
+	}
+}
@@ -0,0 +1,103 @@
+package share
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	mdnsGroup4 = "224.0.0.251:5353"
+	mdnsGroup6 = "[ff02::fb]:5353"
+)
+
+// startMDNS answers multicast DNS queries for host.local with addr until the
+// returned stop function is called.
+func startMDNS(host string, addr *net.IPAddr) (stop func(), err error) {
+	network, groupAddr, rrType := "udp4", mdnsGroup4, dnsmessage.TypeA
+	if addr.IP.To4() == nil {
+		network, groupAddr, rrType = "udp6", mdnsGroup6, dnsmessage.TypeAAAA
+	}
+	group, err := net.ResolveUDPAddr(network, groupAddr)
+	if err != nil {
+		return nil, err
+	}
+	var iface *net.Interface
+	if addr.Zone != "" {
+		if iface, err = net.InterfaceByName(addr.Zone); err != nil {
+			return nil, err
+		}
+		group.Zone = addr.Zone
+	}
+	conn, err := net.ListenMulticastUDP(network, iface, group)
+	if err != nil {
+		return nil, err
+	}
+	name, err := dnsmessage.NewName(host + ".local.")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var body dnsmessage.ResourceBody
+	if rrType == dnsmessage.TypeA {
+		body = &dnsmessage.AResource{A: [4]byte(addr.IP.To4())}
+	} else {
+		body = &dnsmessage.AAAAResource{AAAA: [16]byte(addr.IP.To16())}
+	}
+
+	answer := func(id uint16, q *dnsmessage.Question, unicast bool) []byte {
+		class := dnsmessage.ClassINET
+		if !unicast {
+			class |= 1 << 15 // cache-flush, the name is ours alone
+		}
+		msg := dnsmessage.Message{
+			Header: dnsmessage.Header{ID: id, Response: true, Authoritative: true},
+			Answers: []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: name, Type: rrType, Class: class, TTL: 120},
+				Body:   body,
+			}},
+		}
+		if q != nil {
+			msg.Questions = []dnsmessage.Question{*q}
+		}
+		b, _ := msg.Pack()
+		return b
+	}
+
+	conn.WriteToUDP(answer(0, nil, false), group)
+
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, src, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil || h.Response {
+				continue
+			}
+			questions, err := p.AllQuestions()
+			if err != nil {
+				continue
+			}
+			for _, q := range questions {
+				if !strings.EqualFold(q.Name.String(), name.String()) || (q.Type != rrType && q.Type != dnsmessage.TypeALL) {
+					continue
+				}
+				// Queries from a port other than 5353 come from simple
+				// resolvers that expect a plain unicast DNS reply.
+				if src.Port != 5353 {
+					conn.WriteToUDP(answer(h.ID, &q, true), src)
+				} else {
+					conn.WriteToUDP(answer(0, nil, false), group)
+				}
+				break
+			}
+		}
+	}()
+
+	return func() { conn.Close() }, nil
+}
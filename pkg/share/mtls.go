@@ -0,0 +1,212 @@
+package share
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"html/template"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// clientCertLifetime is how long an issued client certificate is good for:
+// enough to install it and open the note, not more.
+const clientCertLifetime = 15 * time.Minute
+
+var bootstrapPage = template.Must(template.New("bootstrap").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>qreph</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+a.button { display: block; font-size: 1.5em; margin-top: 1em; }
+</style>
+</head>
+<body>
+<p>This note is only released to the device holding its client certificate.</p>
+{{if .Issued}}
+<p>The certificate has been downloaded already. Once it is installed, close this page and open the link again.</p>
+{{else}}
+<ol>
+<li><a class="button" href="?cert=p12" download="qreph.p12">Download the certificate</a></li>
+<li>Install it, entering the password shown on the sender's screen.</li>
+<li><a class="button" href="" onclick="location.reload(); return false">Open the note</a></li>
+</ol>
+<p>The certificate can be downloaded once and works for {{.Minutes}} minutes.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// ClientCA issues a single short-lived client certificate over the note's
+// URL and then lets only requests made with it through. Use it with
+// WithClientCA, which has the server ask for client certificates.
+type ClientCA struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	pool     *x509.CertPool
+	password string
+
+	mu     sync.Mutex
+	issued bool
+}
+
+// NewClientCA makes an ephemeral CA and a password for the PKCS#12 file
+// it hands to browsers.
+func NewClientCA() (*ClientCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "qreph client CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &ClientCA{cert: cert, key: key, pool: pool, password: strings.ToLower(rand.Text()[:8])}, nil
+}
+
+// Password returns the password of the PKCS#12 file, to tell the
+// receiver.
+func (ca *ClientCA) Password() string {
+	return ca.password
+}
+
+// issue makes the one client certificate, or returns nil if it was made
+// before.
+func (ca *ClientCA) issue() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if ca.issued {
+		return nil, nil, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "qreph receiver"},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(clientCertLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca.issued = true
+	return cert, key, nil
+}
+
+// Handler passes requests made with the issued certificate to next. Until
+// it is issued, browsers get a page to download it as PKCS#12 and other
+// clients that send the password as the password parameter get it as PEM,
+// for curl --cert; the PEM key is not encrypted, so it is never handed out
+// without the password. After that, requests without the certificate are
+// refused and leave the note alone.
+func (ca *ClientCA) Handler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The certificate is only sent on a new connection.
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Cache-Control", "no-store")
+		browser := strings.Contains(r.Header.Get("Accept"), "text/html")
+		if browser && r.URL.Query().Get("cert") != "p12" {
+			ca.mu.Lock()
+			issued := ca.issued
+			ca.mu.Unlock()
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if issued {
+				w.WriteHeader(http.StatusForbidden)
+			}
+			bootstrapPage.Execute(w, struct {
+				Issued  bool
+				Minutes int
+			}{issued, int(clientCertLifetime.Minutes())})
+			return
+		}
+		p12 := r.URL.Query().Get("cert") == "p12"
+		if !p12 && subtle.ConstantTimeCompare([]byte(r.PostFormValue("password")), []byte(ca.password)) != 1 {
+			http.Error(w, "POST the password shown on the sender's screen as password", http.StatusForbidden)
+			return
+		}
+		cert, key, err := ca.issue()
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if cert == nil {
+			http.Error(w, "this note needs the client certificate that was already issued", http.StatusForbidden)
+			return
+		}
+		if p12 {
+			// Phones do not import the newer PKCS#12 encryption.
+			data, err := pkcs12.LegacyDES.Encode(key, cert, []*x509.Certificate{ca.cert}, ca.password)
+			if err != nil {
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/x-pkcs12")
+			w.Header().Set("Content-Disposition", `attachment; filename="qreph.p12"`)
+			w.Write(data)
+			return
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		pem.Encode(w, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	}
+}
+
+// tlsConfig asks clients for a certificate from ca, without insisting, so
+// the first request can still come to fetch one.
+func (ca *ClientCA) tlsConfig(c *tls.Config) {
+	c.ClientAuth = tls.VerifyClientCertIfGiven
+	c.ClientCAs = ca.pool
+}
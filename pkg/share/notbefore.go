@@ -0,0 +1,50 @@
+package share
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var notYetPage = template.Must(template.New("notyet").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>qreph</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+</style>
+</head>
+<body>
+<p>This note is not available yet. It can be opened from <time datetime="{{.Machine}}">{{.Human}}</time>; reload the page then.</p>
+<script>
+var t = document.querySelector("time");
+t.textContent = new Date(t.dateTime).toLocaleString();
+</script>
+</body>
+</html>
+`))
+
+// NotBeforeHandler answers requests before t with 503 and a page saying
+// when to come back, leaving the note alone, and passes later ones to next.
+func NotBeforeHandler(t time.Time, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wait := time.Until(t)
+		if wait <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		w.Header().Set("Cache-Control", "no-store")
+		if !wantsHTML(r) {
+			http.Error(w, "this note is not available until "+t.UTC().Format(time.RFC3339), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		notYetPage.Execute(w, struct{ Machine, Human string }{t.UTC().Format(time.RFC3339), t.Format("Jan 2 15:04 MST")})
+	}
+}
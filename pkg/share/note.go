@@ -0,0 +1,131 @@
+// Package share serves one-time notes over HTTP: a Store hands a Note out
+// a limited number of times, NoteHandler and the handlers wrapping it
+// deliver it to browsers and command line clients, and Server listens on
+// the LAN and builds the URLs to put in a QR code.
+package share
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Note is the content being shared. Either Content holds it or Stream
+// writes it, for notes too big to keep in memory.
+type Note struct {
+	Content     []byte
+	Stream      func(w io.Writer) error
+	ContentType string
+	Filename    string // set to make clients save the note under this name
+	View        string // HTML view for browsers, see DetectView
+	Lang        string // language of the code view
+	Sum         string // hex SHA-256 of Content, for receivers to check
+	Sig         string // base64 Ed25519 signature of Content
+
+	wraps *Note // the plaintext of Age and SealE2E notes
+}
+
+// Wipe overwrites the content of n, and of the note it was made from, so
+// the secret does not linger in memory after it has been served.
+func (n *Note) Wipe() {
+	clear(n.Content)
+	if n.wraps != nil {
+		n.wraps.Wipe()
+	}
+}
+
+// Payload is the plaintext of notes that are encrypted as a whole, so
+// the metadata travels inside the ciphertext.
+type Payload struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// Bytes returns the content, running Stream if there is one.
+func (n *Note) Bytes() ([]byte, error) {
+	if n.Stream == nil {
+		return n.Content, nil
+	}
+	var buf bytes.Buffer
+	if err := n.Stream(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// clearCopy wipes content from Bytes once it is done with, if Stream made
+// it; Content itself is wiped with the note.
+func (n *Note) clearCopy(content []byte) {
+	if n.Stream != nil {
+		clear(content)
+	}
+}
+
+// MarshalPayload encodes n as a Payload.
+func (n *Note) MarshalPayload() ([]byte, error) {
+	content, err := n.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Payload{Type: n.ContentType, Name: n.Filename, Data: content})
+}
+
+// ReadFile makes a note of the file at path, typed by its extension.
+func ReadFile(path string) (*Note, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = DetectContentType(content)
+	}
+	return &Note{
+		Content:     content,
+		ContentType: contentType,
+		Filename:    filepath.Base(path),
+	}, nil
+}
+
+// DetectContentType sniffs content like browsers do, with JSON added since
+// it would otherwise pass as plain text.
+func DetectContentType(content []byte) string {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return "application/json"
+	}
+	return http.DetectContentType(content)
+}
+
+// Dir makes a note that streams the directory at path as a zip archive.
+func Dir(path string) (*Note, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Note{
+		Stream: func(w io.Writer) error {
+			zw := zip.NewWriter(w)
+			if err := zw.AddFS(os.DirFS(abs)); err != nil {
+				return err
+			}
+			return zw.Close()
+		},
+		ContentType: "application/zip",
+		Filename:    filepath.Base(abs) + ".zip",
+	}, nil
+}
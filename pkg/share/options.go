@@ -0,0 +1,233 @@
+package share
+
+import (
+	"crypto/ecdsa"
+	"log"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Option configures New, NewStore and NewHandler. Each of them uses the
+// options that make sense for it and ignores the rest.
+type Option func(*config)
+
+type config struct {
+	tls          bool
+	tlsKey       *ecdsa.PrivateKey
+	http3        bool
+	clientCA     *ClientCA
+	ttl          time.Duration
+	maxDownloads int
+	logger       *log.Logger
+	accessLog    *slog.Logger
+	compress     bool
+	onDelivery   func(Delivery)
+
+	mdns      bool
+	iface     string
+	ip        string
+	port      int
+	listener  net.Listener
+	publicURL string
+	allIfaces bool
+	wan       bool
+	tailscale bool
+	rate      int
+	allow     []*net.IPNet
+	lanOnly   bool
+	hostCheck bool
+	perMinute int
+	banAfter  int
+	maxConns  int
+	linkTTL   time.Duration
+	linkKey   []byte
+
+	contentType string
+	filename    string
+}
+
+func newConfig(opts []Option) config {
+	c := config{maxDownloads: 1, logger: log.Default()}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// WithTLS serves HTTPS with an ephemeral self-signed certificate.
+func WithTLS() Option {
+	return func(c *config) { c.tls = true }
+}
+
+// WithTLSKey makes WithTLS use key for its certificate instead of a fresh
+// one, so the SPKI pin in URLs stays the same across restarts.
+func WithTLSKey(key *ecdsa.PrivateKey) Option {
+	return func(c *config) { c.tlsKey = key }
+}
+
+// WithHTTP3 also serves HTTP/3 over QUIC on the same port and advertises
+// it with Alt-Svc on responses over TCP. It needs WithTLS.
+func WithHTTP3() Option {
+	return func(c *config) { c.http3 = true }
+}
+
+// WithClientCA has the server ask clients for certificates issued by ca,
+// for ClientCA.Handler. It needs WithTLS.
+func WithClientCA(ca *ClientCA) Option {
+	return func(c *config) { c.clientCA = ca }
+}
+
+// WithTTL shuts the server down, or burns the note of a handler, once d
+// has passed without the note being fetched.
+func WithTTL(d time.Duration) Option {
+	return func(c *config) { c.ttl = d }
+}
+
+// WithMaxDownloads hands the note out n times before it burns, the default
+// is once. n <= 0 keeps it until it is burned explicitly.
+func WithMaxDownloads(n int) Option {
+	return func(c *config) { c.maxDownloads = n }
+}
+
+// WithLogger sends the log messages of servers and handlers to l instead
+// of the standard logger.
+func WithLogger(l *log.Logger) Option {
+	return func(c *config) { c.logger = l }
+}
+
+// WithAccessLog logs every request a server answers to l: the peer,
+// method, whether it hit a note, status, bytes sent and User-Agent. At
+// Debug it adds connections and TLS handshakes, at LevelTrace the request
+// headers.
+func WithAccessLog(l *slog.Logger) Option {
+	return func(c *config) { c.accessLog = l }
+}
+
+// WithOnDelivery calls f after every completed fetch of the note, before the
+// last one shuts the server down. f runs in the request's goroutine.
+func WithOnDelivery(f func(Delivery)) Option {
+	return func(c *config) { c.onDelivery = f }
+}
+
+// WithCompression sends text notes gzip or zstd encoded to clients that
+// accept it.
+func WithCompression() Option {
+	return func(c *config) { c.compress = true }
+}
+
+// WithMDNS answers mDNS queries for qreph.local and uses it in URLs.
+func WithMDNS() Option {
+	return func(c *config) { c.mdns = true }
+}
+
+// WithInterface advertises the address of the network interface name.
+func WithInterface(name string) Option {
+	return func(c *config) { c.iface = name }
+}
+
+// WithIP advertises addr instead of guessing the LAN address.
+func WithIP(addr string) Option {
+	return func(c *config) { c.ip = addr }
+}
+
+// WithPort listens on port instead of a random one.
+func WithPort(port int) Option {
+	return func(c *config) { c.port = port }
+}
+
+// WithListener serves on l, a listener opened by someone else such as
+// systemd, instead of listening itself. It overrides WithPort. Anything but
+// TCP, such as a unix socket behind a reverse proxy, needs WithPublicURL.
+func WithListener(l net.Listener) Option {
+	return func(c *config) { c.listener = l }
+}
+
+// WithPublicURL advertises url, for servers behind a proxy or port
+// forward.
+func WithPublicURL(url string) Option {
+	return func(c *config) { c.publicURL = url }
+}
+
+// WithAllInterfaces advertises every usable network interface.
+func WithAllInterfaces() Option {
+	return func(c *config) { c.allIfaces = true }
+}
+
+// WithWAN forwards a port on the router via NAT-PMP or UPnP and advertises
+// the public address.
+func WithWAN() Option {
+	return func(c *config) { c.wan = true }
+}
+
+// WithTailscale joins the tailnet as a tsnet node of its own, listens only
+// there and advertises the node's MagicDNS name. WithPort picks the port,
+// 80 or 443 with WithTLS by default.
+func WithTailscale() Option {
+	return func(c *config) { c.tailscale = true }
+}
+
+// WithRateLimit caps all responses together at bytesPerSec.
+func WithRateLimit(bytesPerSec int) Option {
+	return func(c *config) { c.rate = bytesPerSec }
+}
+
+// WithAllowedNets only serves peers in nets. Everyone else gets a 404.
+func WithAllowedNets(nets ...*net.IPNet) Option {
+	return func(c *config) { c.allow = append(c.allow, nets...) }
+}
+
+// WithLANOnly only serves peers on the subnet of an advertised address,
+// so the note cannot be reached through a router or port forward.
+func WithLANOnly() Option {
+	return func(c *config) { c.lanOnly = true }
+}
+
+// WithHostCheck refuses requests whose Host header is not one of the
+// advertised names, against DNS rebinding. It has no effect on listeners
+// other than TCP, where a proxy decides what Host is.
+func WithHostCheck() Option {
+	return func(c *config) { c.hostCheck = true }
+}
+
+// WithRequestLimit answers peers asking more than perMinute times a
+// minute with 429.
+func WithRequestLimit(perMinute int) Option {
+	return func(c *config) { c.perMinute = perMinute }
+}
+
+// WithBanAfter shuts a peer out for ten minutes after n requests in a row
+// for paths that are not there.
+func WithBanAfter(n int) Option {
+	return func(c *config) { c.banAfter = n }
+}
+
+// WithMaxConns keeps at most n connections open at once. Further ones
+// wait to be accepted.
+func WithMaxConns(n int) Option {
+	return func(c *config) { c.maxConns = n }
+}
+
+// WithLinkExpiry makes SignPath sign paths with a time ttl from then, after
+// which their URLs stop working. Serve only routes signed paths.
+func WithLinkExpiry(ttl time.Duration) Option {
+	return func(c *config) { c.linkTTL = ttl }
+}
+
+// WithLinkKey makes WithLinkExpiry sign with key instead of a fresh one, so
+// URLs stay good across restarts.
+func WithLinkKey(key []byte) Option {
+	return func(c *config) { c.linkKey = key }
+}
+
+// WithContentType serves the note of NewHandler as MIME type t instead of
+// the detected one.
+func WithContentType(t string) Option {
+	return func(c *config) { c.contentType = t }
+}
+
+// WithFilename makes clients save the note of NewHandler as a file called
+// name.
+func WithFilename(name string) Option {
+	return func(c *config) { c.filename = name }
+}
@@ -0,0 +1,62 @@
+package share
+
+// cryptoPageHead and cryptoPageFoot wrap the scripts of pages that decrypt a
+// note in the browser. The script in between runs inside an async function
+// with out, the base64 helpers and reveal in scope, once the receiver has
+// clicked the button; thrown errors are shown in place of the note.
+const cryptoPageHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>qreph</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { white-space: pre-wrap; word-break: break-all; }
+button { font-size: 1.5em; width: 100%; }
+</style>
+</head>
+<body>
+<button id="show">Show note</button>
+<pre id="out"></pre>
+<script>
+(async function () {
+	var out = document.getElementById("out");
+	var show = document.getElementById("show");
+	function b64(buf) { return btoa(String.fromCharCode.apply(null, new Uint8Array(buf))); }
+	function unb64(s) { return Uint8Array.from(atob(s), function (c) { return c.charCodeAt(0); }); }
+	function unb64url(s) { return unb64(s.replace(/-/g, "+").replace(/_/g, "/")); }
+	function equal(a, b) { return a.length === b.length && a.every(function (v, i) { return v === b[i]; }); }
+	function reveal(data, type, name) {
+		if (!name && type.indexOf("text/") === 0) {
+			out.textContent = new TextDecoder().decode(data);
+			return;
+		}
+		var a = document.createElement("a");
+		a.href = URL.createObjectURL(new Blob([data], {type: type}));
+		a.download = name || "note";
+		a.textContent = "Download " + a.download;
+		out.textContent = "";
+		out.appendChild(a);
+	}
+	// The PIN gate keeps the fragment here rather than send it to the server.
+	var stashed = sessionStorage.getItem("qreph-hash");
+	sessionStorage.removeItem("qreph-hash");
+	if (stashed && !location.hash) history.replaceState(null, "", location.pathname + location.search + stashed);
+	try {
+		if (!window.crypto || !crypto.subtle) throw new Error("This browser only allows decryption over HTTPS.");
+		// Link previewers that run scripts still never click.
+		await new Promise(function (resolve) { show.addEventListener("click", resolve); });
+		show.remove();
+		out.textContent = "Loading...";
+`
+
+const cryptoPageFoot = `	} catch (e) {
+		show.remove();
+		out.textContent = e.message;
+	}
+})();
+</script>
+</body>
+</html>
+`
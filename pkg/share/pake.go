@@ -0,0 +1,243 @@
+package share
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gtank/ristretto255"
+)
+
+// Code phrase transfers run CPace over ristretto255: both sides derive a
+// generator from the phrase and the URL path, exchange one element each, and
+// encrypt with a key only a peer knowing the phrase can compute. A wrong
+// guess burns the note without revealing anything about the phrase.
+const pakeContext = "qreph-cpace-v1"
+
+// ErrWrongCode means the code phrase did not match, or the response was
+// tampered with. Either way the note is gone.
+var ErrWrongCode = errors.New("wrong code phrase or tampered response, the note is gone")
+
+var codeWords = [256]string{
+	"acid", "acorn", "actor", "adobe", "agent", "alarm", "album", "alien",
+	"alley", "amber", "anchor", "angle", "ankle", "apple", "apron", "arena",
+	"armor", "arrow", "atlas", "attic", "autumn", "bacon", "badge", "bagel",
+	"baker", "bamboo", "banana", "banjo", "barrel", "basil", "basket",
+	"beacon", "beaver", "bench", "berry", "bicycle", "bishop", "blanket",
+	"blossom", "bonnet", "bottle", "bracket", "breeze", "bridge", "bubble",
+	"bucket", "bundle", "butter", "button", "cabin", "cactus", "camel",
+	"candle", "canoe", "canyon", "carbon", "carpet", "castle", "cedar",
+	"cello", "cherry", "chimney", "cinder", "circus", "citrus", "clover",
+	"cobalt", "comet", "compass", "copper", "coral", "cotton", "cowboy",
+	"crayon", "cricket", "crystal", "dagger", "daisy", "dancer", "delta",
+	"denim", "desert", "diesel", "dingo", "dolphin", "domino", "donkey",
+	"dragon", "dune", "eagle", "easel", "echo", "eclipse", "ember", "emerald",
+	"engine", "falcon", "feather", "fender", "fiddle", "finch", "fjord",
+	"flannel", "flute", "fossil", "fountain", "fox", "galaxy", "garden",
+	"garlic", "gecko", "geyser", "ginger", "glacier", "goblin", "gopher",
+	"granite", "gravel", "guitar", "hammer", "harbor", "harp", "hazel",
+	"helmet", "hermit", "hippo", "honey", "hornet", "husky", "igloo",
+	"indigo", "island", "ivory", "jacket", "jaguar", "jelly", "jester",
+	"jigsaw", "jungle", "kayak", "kernel", "kettle", "kiwi", "koala",
+	"ladder", "lagoon", "lantern", "lasso", "lemon", "lentil", "lilac",
+	"lizard", "llama", "locket", "lotus", "magnet", "mango", "maple",
+	"marble", "meadow", "melon", "meteor", "mitten", "monkey", "mosaic",
+	"muffin", "nectar", "needle", "nickel", "noodle", "nutmeg", "oasis",
+	"olive", "onion", "orbit", "orchid", "otter", "oyster", "paddle", "panda",
+	"parrot", "pebble", "pepper", "pickle", "pigeon", "pillow", "pilot",
+	"pine", "pirate", "pixel", "planet", "plum", "pocket", "pony", "poppy",
+	"potato", "prism", "pumpkin", "puzzle", "quartz", "quill", "rabbit",
+	"radar", "radish", "raven", "ribbon", "river", "robin", "rocket",
+	"saddle", "salmon", "sandal", "satin", "scarf", "shadow", "silver",
+	"sketch", "sleet", "sonnet", "spider", "sponge", "spruce", "squid",
+	"stable", "summit", "sunset", "swan", "tango", "teapot", "thistle",
+	"thunder", "tiger", "timber", "toffee", "tomato", "topaz", "trumpet",
+	"tulip", "tundra", "turtle", "unicorn", "valley", "velvet", "violin",
+	"vortex", "waffle", "walnut", "walrus", "willow", "wizard", "yarrow",
+	"yodel", "zebra", "zephyr", "zinnia", "zipper",
+}
+
+// NewCodePhrase picks three random words for PAKEHandler.
+func NewCodePhrase() (string, error) {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return codeWords[b[0]] + "-" + codeWords[b[1]] + "-" + codeWords[b[2]], nil
+}
+
+func pakeGenerator(code, path string) *ristretto255.Element {
+	h := sha512.New()
+	for _, s := range []string{pakeContext, code, path} {
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	return ristretto255.NewElement().FromUniformBytes(h.Sum(nil))
+}
+
+func pakeShare(code, path string) (*ristretto255.Scalar, []byte, error) {
+	b := make([]byte, 64)
+	if _, err := rand.Read(b); err != nil {
+		return nil, nil, err
+	}
+	x := ristretto255.NewScalar().FromUniformBytes(b)
+	share := ristretto255.NewElement().ScalarMult(x, pakeGenerator(code, path))
+	return x, share.Encode(nil), nil
+}
+
+func pakeKey(x *ristretto255.Scalar, peer, receiverShare, senderShare []byte) (cipher.AEAD, error) {
+	y := ristretto255.NewElement()
+	if err := y.Decode(peer); err != nil {
+		return nil, err
+	}
+	k := ristretto255.NewElement().ScalarMult(x, y)
+	if k.Equal(ristretto255.NewIdentityElement()) == 1 {
+		return nil, errors.New("invalid key share")
+	}
+	secret := append(k.Encode(nil), receiverShare...)
+	secret = append(secret, senderShare...)
+	key, err := hkdf.Key(sha256.New, secret, nil, pakeContext, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PAKEHandler answers FetchCode with the note sealed under a key derived
+// from code, and turns away everything else.
+func PAKEHandler(store *Store, code, path string, done chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "this note is protected by a code phrase, fetch it with: qreph fetch <url>", http.StatusBadRequest)
+			return
+		}
+		receiverShare, err := io.ReadAll(io.LimitReader(r.Body, 64))
+		if err != nil || !validShare(receiverShare) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		n, last := store.Get()
+		if n == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		ciphertext, err := sealPAKE(n, code, path, receiverShare)
+		if err != nil {
+			store.logger.Printf("failed to encrypt note, it is still available: %v", err)
+			store.Release(n)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		written, err := w.Write(ciphertext)
+		if err != nil {
+			store.logger.Printf("failed to deliver note, it is still available: %v", err)
+			store.Release(n)
+			return
+		}
+		store.report(r, written)
+		if last {
+			close(done)
+		}
+	}
+}
+
+// validShare reports whether share encodes a ristretto255 element other
+// than the identity, so a malformed one is turned away before it can use
+// up the note.
+func validShare(share []byte) bool {
+	e := ristretto255.NewElement()
+	return len(share) == 32 && e.Decode(share) == nil && e.Equal(ristretto255.NewIdentityElement()) == 0
+}
+
+func sealPAKE(n *Note, code, path string, receiverShare []byte) ([]byte, error) {
+	plaintext, err := n.MarshalPayload()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(plaintext)
+
+	x, senderShare, err := pakeShare(code, path)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := pakeKey(x, receiverShare, receiverShare, senderShare)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(senderShare, nonce...)
+	return aead.Seal(out, nonce, plaintext, nil), nil
+}
+
+// FetchCode downloads a note protected by a code phrase from rawURL. A
+// wrong phrase burns the note on the server.
+func FetchCode(rawURL, code string) (*Payload, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	x, receiverShare, err := pakeShare(code, u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key share: %w", err)
+	}
+
+	// The exchange authenticates the sender, so the certificate does not
+	// need to be trusted.
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Post(u.String(), "application/octet-stream", bytes.NewReader(receiverShare))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < 32 {
+		return nil, errors.New("short response")
+	}
+
+	senderShare := body[:32]
+	aead, err := pakeKey(x, senderShare, receiverShare, senderShare)
+	if err != nil {
+		return nil, fmt.Errorf("key exchange failed: %w", err)
+	}
+	if len(body) < 32+aead.NonceSize() {
+		return nil, errors.New("short response")
+	}
+	nonce := body[32 : 32+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, body[32+aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrWrongCode
+	}
+
+	var p Payload
+	if err := json.Unmarshal(plaintext, &p); err != nil {
+		return nil, fmt.Errorf("failed to decode note: %w", err)
+	}
+	return &p, nil
+}
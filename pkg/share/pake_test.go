@@ -0,0 +1,82 @@
+package share
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func pakeServer(t *testing.T, code string) (*Store, string, chan struct{}) {
+	store := NewStore(&Note{Content: []byte("hello"), ContentType: "text/plain", Filename: "note.txt"}, quiet)
+	done := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("/note", PAKEHandler(store, code, "/note", done))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return store, srv.URL + "/note", done
+}
+
+func TestFetchCode(t *testing.T) {
+	code, err := NewCodePhrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, url, done := pakeServer(t, code)
+	p, err := FetchCode(url, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.Data) != "hello" || p.Type != "text/plain" || p.Name != "note.txt" {
+		t.Fatalf("got %+v", p)
+	}
+	if store.Peek() != nil || !closed(done) {
+		t.Fatal("the note is still there")
+	}
+	if _, err := FetchCode(url, code); err == nil {
+		t.Fatal("fetched twice")
+	}
+}
+
+func TestFetchCodeWrongPhrase(t *testing.T) {
+	store, url, done := pakeServer(t, "acid-acorn-actor")
+	if _, err := FetchCode(url, "acid-acorn-alien"); !errors.Is(err, ErrWrongCode) {
+		t.Fatalf("got %v, want ErrWrongCode", err)
+	}
+	// A wrong guess burns the note.
+	if store.Peek() != nil || !closed(done) {
+		t.Fatal("the note survived a wrong phrase")
+	}
+}
+
+// Shares that are not an element, or are the identity, are turned away
+// and leave the note alone.
+func TestPAKEHandlerBadShare(t *testing.T) {
+	tests := []struct {
+		name  string
+		share []byte
+	}{
+		{"short", make([]byte, 31)},
+		{"long", make([]byte, 33)},
+		{"identity", make([]byte, 32)},
+		{"not canonical", bytes.Repeat([]byte{0xff}, 32)},
+		{"negative", append([]byte{1}, make([]byte, 31)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, url, done := pakeServer(t, "acid-acorn-actor")
+			resp, err := http.Post(url, "application/octet-stream", bytes.NewReader(tt.share))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("got %d, want %d", resp.StatusCode, http.StatusBadRequest)
+			}
+			if store.Peek() == nil || closed(done) {
+				t.Fatal("a bad share used up the note")
+			}
+		})
+	}
+}
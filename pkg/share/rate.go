@@ -0,0 +1,84 @@
+package share
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseRate reads a transfer rate such as 1MB/s, 500K or 2m. Units are
+// powers of 1024, as with curl --limit-rate.
+func ParseRate(s string) (int, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(v, "/S")
+	v = strings.TrimSuffix(v, "B")
+	mult := 1
+	switch {
+	case strings.HasSuffix(v, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(v, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(v, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		v = v[:len(v)-1]
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid rate %q, want e.g. 1MB/s or 500K", s)
+	}
+	return int(f * float64(mult)), nil
+}
+
+// rateLimiter spaces out writes so that all responses together stay under
+// bytesPerSec.
+type rateLimiter struct {
+	bytesPerSec int
+	mu          sync.Mutex
+	next        time.Time
+}
+
+// wait blocks for as long as n bytes take at the limit, counting from
+// where earlier writes left off.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.bytesPerSec))
+	at := l.next
+	l.mu.Unlock()
+	time.Sleep(time.Until(at))
+}
+
+type limitedWriter struct {
+	http.ResponseWriter
+	limiter *rateLimiter
+}
+
+func (w limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Small chunks keep very low limits from going out in bursts.
+		chunk := min(len(p), 16<<10, max(w.limiter.bytesPerSec/10, 1))
+		w.limiter.wait(chunk)
+		n, err := w.ResponseWriter.Write(p[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[chunk:]
+	}
+	return written, nil
+}
+
+func limitRate(limiter *rateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(limitedWriter{w, limiter}, r)
+	})
+}
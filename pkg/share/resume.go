@@ -0,0 +1,267 @@
+package share
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// In-memory notes are served with http.ServeContent, so a download that
+// breaks off can be picked up again with a Range request. The first
+// response that sends a client any of the note claims a fetch for it:
+// until that client has every byte, in one response or spread over
+// several, or resumeWindow passes, nobody else is sent that fetch. A
+// download broken off on purpose, one byte short, still uses it up. With
+// --keep every client may fetch, so there is nothing to claim, but what
+// went out is still tracked for each client.
+
+type span struct{ from, to int }
+
+// resume is what went out to one client so far.
+type resume struct {
+	spans []span
+}
+
+// resumeWindow is how long a client that was sent part of the note has to
+// fetch the rest.
+const resumeWindow = time.Minute
+
+// maxResumes bounds the clients tracked with --keep; past it the partial
+// downloads so far are forgotten and have to start over to count.
+const maxResumes = 256
+
+// resumeKey tells clients apart for the spans they have been sent.
+func resumeKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host + " " + r.UserAgent()
+}
+
+// deliveredWriter remembers what a ServeContent response actually sent.
+type deliveredWriter struct {
+	http.ResponseWriter
+	status  int
+	written int
+}
+
+func (w *deliveredWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *deliveredWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += n
+	return n, err
+}
+
+// sent returns the part of the note the response body covered.
+func (w *deliveredWriter) sent() (span, bool) {
+	switch w.status {
+	case http.StatusOK:
+		return span{0, w.written}, true
+	case http.StatusPartialContent:
+		var from, to, size int
+		if _, err := fmt.Sscanf(w.Header().Get("Content-Range"), "bytes %d-%d/%d", &from, &to, &size); err != nil {
+			return span{}, false
+		}
+		return span{from, from + w.written}, true
+	}
+	return span{}, false
+}
+
+// peekFor returns the note if client may be sent it now: with --keep
+// always, otherwise while there is a fetch left that nobody else has
+// claimed, or client claimed one.
+func (s *Store) peekFor(client string) *Note {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.resumes[client]; ok || s.keep || s.remaining > 0 {
+		return s.note
+	}
+	return nil
+}
+
+// delivered records that the bytes in sent went out to client and reports
+// whether client has the whole note now. Without --keep the first bytes
+// claim a fetch, and done is closed once the last one is complete or
+// abandoned.
+func (s *Store) delivered(n *Note, client string, sent span, done chan struct{}) (complete bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.note != n {
+		return false
+	}
+	r, ok := s.resumes[client]
+	if !ok {
+		switch {
+		case s.keep && len(s.resumes) >= maxResumes:
+			clear(s.resumes)
+		case !s.keep:
+			if s.remaining <= 0 {
+				return false
+			}
+			s.remaining--
+		}
+		if s.resumes == nil {
+			s.resumes = make(map[string]*resume)
+		}
+		r = &resume{}
+		s.resumes[client] = r
+		if !s.keep {
+			time.AfterFunc(resumeWindow, func() { s.abandon(n, client, r, done) })
+		}
+	}
+	r.spans = mergeSpans(append(r.spans, sent))
+	if !covers(r.spans, len(n.Content)) {
+		return false
+	}
+	delete(s.resumes, client)
+	s.finish(done)
+	return true
+}
+
+// abandon gives up on r, a fetch claimed by client, if client has not
+// finished it in time. It stays used up.
+func (s *Store) abandon(n *Note, client string, r *resume, done chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.note != n || s.resumes[client] != r {
+		return
+	}
+	delete(s.resumes, client)
+	s.logger.Printf("note partly delivered and not resumed within %s, the fetch is used up", resumeWindow)
+	s.finish(done)
+}
+
+// finish drops the note and closes done once every fetch has been claimed
+// and none is still being resumed. s.mu must be held.
+func (s *Store) finish(done chan struct{}) {
+	if s.keep || s.remaining > 0 || len(s.resumes) > 0 {
+		return
+	}
+	s.note = nil
+	close(done)
+}
+
+// covers reports whether merged spans are the whole of size bytes.
+func covers(spans []span, size int) bool {
+	return size == 0 || len(spans) == 1 && spans[0].from == 0 && spans[0].to >= size
+}
+
+func mergeSpans(spans []span) []span {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].from < spans[j].from })
+	merged := spans[:0]
+	for _, sp := range spans {
+		if sp.from >= sp.to {
+			continue
+		}
+		if k := len(merged) - 1; k >= 0 && sp.from <= merged[k].to {
+			merged[k].to = max(merged[k].to, sp.to)
+			continue
+		}
+		merged = append(merged, sp)
+	}
+	return merged
+}
+
+// serveContent hands out an in-memory note, honouring Range and If-Range.
+// Deliveries are serialized so two clients cannot both get the whole note
+// while it is only counted once.
+func serveContent(w http.ResponseWriter, r *http.Request, store *Store, done chan struct{}) {
+	if !store.keep {
+		store.deliver.Lock()
+		defer store.deliver.Unlock()
+	}
+	client := resumeKey(r)
+	n := store.peekFor(client)
+	if n == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	dw := &deliveredWriter{ResponseWriter: w}
+	// failed reports a whole-note response that broke off. Once some of it
+	// went out it counts like a partial download.
+	failed := func(err error) {
+		if dw.written == 0 {
+			store.logger.Printf("failed to deliver note, it is still available: %v", err)
+			return
+		}
+		store.logger.Printf("failed to deliver note after %d bytes: %v", dw.written, err)
+		store.delivered(n, client, span{}, done)
+	}
+	if n.View != "" && wantsHTML(r) {
+		if err := writeView(dw, n); err != nil {
+			failed(err)
+			return
+		}
+		store.report(r, dw.written)
+		store.delivered(n, client, span{0, len(n.Content)}, done)
+		return
+	}
+
+	// A resuming download only ever asks for one range, anything fancier
+	// gets the whole note.
+	if strings.Contains(r.Header.Get("Range"), ",") {
+		r.Header.Del("Range")
+	}
+	setNoteHeaders(w, n)
+	w.Header().Set("ETag", store.etag(n))
+	if store.compress && compressible(n) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		// Ranges refer to the uncompressed bytes, so only whole fetches
+		// are compressed.
+		if enc := negotiateEncoding(r); enc != "" && r.Header.Get("Range") == "" && r.Method != http.MethodHead {
+			w.Header().Set("ETag", strings.TrimSuffix(store.etag(n), `"`)+"-"+enc+`"`)
+			w.Header().Set("Accept-Ranges", "none")
+			if err := writeCompressed(dw, n, enc); err != nil {
+				failed(err)
+				return
+			}
+			store.report(r, dw.written)
+			store.delivered(n, client, span{0, len(n.Content)}, done)
+			return
+		}
+	}
+	http.ServeContent(dw, r, "", time.Time{}, bytes.NewReader(n.Content))
+	if r.Method == http.MethodHead {
+		return
+	}
+	sent, ok := dw.sent()
+	if !ok || (dw.written == 0 && len(n.Content) > 0) {
+		return
+	}
+	if store.delivered(n, client, sent, done) {
+		store.report(r, dw.written)
+	} else {
+		store.logger.Printf("note partly delivered (bytes %d-%d of %d), the rest can be fetched within %s", sent.from, sent.to-1, len(n.Content), resumeWindow)
+	}
+}
+
+// etag names the note's content so a resumed download can tell it is
+// still picking up the same bytes.
+func (s *Store) etag(n *Note) string {
+	s.etagOnce.Do(func() {
+		sum := n.Sum
+		if sum == "" {
+			b := sha256.Sum256(n.Content)
+			sum = hex.EncodeToString(b[:])
+		}
+		s.etagValue = `"` + sum[:32] + `"`
+	})
+	return s.etagValue
+}
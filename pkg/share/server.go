@@ -0,0 +1,435 @@
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/netutil"
+)
+
+// Timeouts of the HTTP server, so a peer that sends nothing cannot hold a
+// connection. There is no write timeout: a big note over a slow link or
+// --limit-rate takes as long as it takes.
+const (
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = time.Minute
+)
+
+// endpoint is one address the server is advertised under. name labels it
+// when there are several.
+type endpoint struct {
+	name string
+	host string
+	port int // 0 means the listener's port
+}
+
+// Server is a listener together with the addresses it is advertised under.
+type Server struct {
+	opts       config
+	endpoints  []endpoint
+	listener   net.Listener
+	scheme     string
+	cert       *tls.Certificate
+	tlsConfig  *tls.Config
+	lanNets    []*net.IPNet // with WithLANOnly
+	links      *linkSigner  // with WithLinkExpiry
+	cleanup    []func()
+	httpServer *http.Server
+	h3         *http3.Server
+	h3Conn     net.PacketConn
+	expired    chan struct{}
+	closeOnce  sync.Once
+}
+
+func getOutboundIP() (*net.IPAddr, error) {
+	conn, err := net.Dial("udp4", "8.8.8.8:80")
+	if err != nil {
+		var err6 error
+		conn, err6 = net.Dial("udp6", "[2001:4860:4860::8888]:80")
+		if err6 != nil {
+			return nil, err
+		}
+	}
+	defer conn.Close()
+
+	localAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, errors.New("could not assert type to *net.UDPAddr")
+	}
+
+	return &net.IPAddr{IP: localAddr.IP, Zone: localAddr.Zone}, nil
+}
+
+// interfaceIP prefers IPv4, then global IPv6, then link-local IPv6 scoped to
+// the interface.
+func interfaceIP(name string) (*net.IPAddr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var global, linkLocal net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		switch ip := ipnet.IP; {
+		case ip.To4() != nil && ip.IsGlobalUnicast():
+			return &net.IPAddr{IP: ip}, nil
+		case ip.IsGlobalUnicast() && global == nil:
+			global = ip
+		case ip.IsLinkLocalUnicast() && ip.To4() == nil && linkLocal == nil:
+			linkLocal = ip
+		}
+	}
+	switch {
+	case global != nil:
+		return &net.IPAddr{IP: global}, nil
+	case linkLocal != nil:
+		return &net.IPAddr{IP: linkLocal, Zone: iface.Name}, nil
+	}
+	return nil, fmt.Errorf("interface %s has no usable address", name)
+}
+
+func candidateAddrs() ([]string, []*net.IPAddr) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil
+	}
+	var names []string
+	var addrs []*net.IPAddr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addr, err := interfaceIP(iface.Name)
+		if err != nil {
+			continue
+		}
+		names = append(names, iface.Name)
+		addrs = append(addrs, addr)
+	}
+	return names, addrs
+}
+
+func advertisedIP(c config) (*net.IPAddr, error) {
+	switch {
+	case c.iface != "" && c.ip != "":
+		return nil, errors.New("an interface and an address cannot both be given")
+	case c.iface != "":
+		return interfaceIP(c.iface)
+	case c.ip != "":
+		host, zone, _ := strings.Cut(c.ip, "%")
+		ip := net.ParseIP(strings.Trim(host, "[]"))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", c.ip)
+		}
+		return &net.IPAddr{IP: ip, Zone: zone}, nil
+	default:
+		return getOutboundIP()
+	}
+}
+
+// urlHost formats addr for the host part of a URL, bracketing IPv6 and
+// escaping the zone separator as RFC 6874 requires.
+func urlHost(addr *net.IPAddr) string {
+	if addr.IP.To4() != nil {
+		return addr.IP.String()
+	}
+	host := addr.IP.String()
+	if addr.Zone != "" {
+		host += "%25" + addr.Zone
+	}
+	return "[" + host + "]"
+}
+
+// RandomPath returns an unguessable URL path.
+func RandomPath() (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return "/" + base64.URLEncoding.EncodeToString(randomBytes), nil
+}
+
+// New starts listening as opts say. Nothing is served until Serve. The
+// address options are alternatives: at most one of WithPublicURL,
+// WithAllInterfaces, WithWAN and WithTailscale, and WithInterface or WithIP
+// only without the last three.
+func New(opts ...Option) (*Server, error) {
+	c := newConfig(opts)
+	if c.publicURL != "" {
+		u, err := url.Parse(c.publicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid public URL %q", c.publicURL)
+		}
+	}
+
+	var names []string
+	var addrs []*net.IPAddr
+	var tailnetName string
+	var tailnet net.Listener
+	var leaveTailnet func()
+	if c.tailscale {
+		if c.listener != nil {
+			return nil, errors.New("Tailscale cannot be combined with a listener")
+		}
+		// The node has the tailnet address to itself, so a fixed port
+		// does for URLs.
+		port := c.port
+		if port == 0 {
+			port = 80
+			if c.tls {
+				port = 443
+			}
+		}
+		var ip net.IP
+		var err error
+		tailnet, tailnetName, ip, leaveTailnet, err = listenTailnet(port, c.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to join the tailnet: %w", err)
+		}
+		names, addrs = []string{""}, []*net.IPAddr{{IP: ip}}
+	} else if c.allIfaces {
+		names, addrs = candidateAddrs()
+		if len(addrs) == 0 {
+			return nil, errors.New("failed to pick an address: no usable network interfaces")
+		}
+	} else {
+		addr, err := advertisedIP(c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pick an address: %w", err)
+		}
+		names, addrs = []string{""}, []*net.IPAddr{addr}
+	}
+
+	listener := c.listener
+	if tailnet != nil {
+		listener = tailnet
+	} else if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(c.port)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create listener: %w", err)
+		}
+	} else if _, ok := listener.Addr().(*net.TCPAddr); !ok {
+		// There is no port to put in a URL or forward.
+		if c.publicURL == "" {
+			return nil, fmt.Errorf("listener on %s is not TCP and needs a public URL", listener.Addr())
+		}
+		if c.wan {
+			return nil, fmt.Errorf("listener on %s is not TCP and cannot be forwarded", listener.Addr())
+		}
+	}
+
+	if c.maxConns > 0 {
+		listener = netutil.LimitListener(listener, c.maxConns)
+	}
+
+	s := &Server{opts: c, listener: listener, scheme: "http", expired: make(chan struct{})}
+	if leaveTailnet != nil {
+		s.cleanup = append(s.cleanup, leaveTailnet)
+	}
+	s.httpServer = &http.Server{
+		ErrorLog:          c.logger,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	if c.linkTTL > 0 {
+		s.links = &linkSigner{key: c.linkKey, ttl: c.linkTTL}
+		if s.links.key == nil {
+			s.links.key = make([]byte, 32)
+			rand.Read(s.links.key)
+		}
+	}
+	if err := s.setup(names, addrs, tailnetName); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Server) setup(names []string, addrs []*net.IPAddr, tailnetName string) error {
+	var ips []net.IP
+	for i, addr := range addrs {
+		s.endpoints = append(s.endpoints, endpoint{name: names[i], host: urlHost(addr)})
+		ips = append(ips, addr.IP)
+	}
+	if s.opts.lanOnly {
+		if s.opts.wan || s.opts.tailscale {
+			return errors.New("LAN only serving cannot be combined with WAN or Tailscale")
+		}
+		nets, err := localNets(addrs)
+		if err != nil {
+			return fmt.Errorf("failed to find the local network: %w", err)
+		}
+		s.lanNets = nets
+	}
+	if s.opts.wan {
+		m, err := mapPort(addrs[0].IP, s.listener.Addr().(*net.TCPAddr).Port)
+		if err != nil {
+			return fmt.Errorf("failed to map port: %w", err)
+		}
+		s.endpoints[0] = endpoint{host: urlHost(&net.IPAddr{IP: m.externalIP}), port: m.externalPort}
+		s.cleanup = append(s.cleanup, m.stop)
+		ips = append(ips, m.externalIP)
+	}
+	var dnsNames []string
+	if tailnetName != "" {
+		s.endpoints[0].host = tailnetName
+		dnsNames = append(dnsNames, tailnetName)
+	}
+	if s.opts.mdns {
+		stop, err := startMDNS("qreph", addrs[0])
+		if err != nil {
+			return fmt.Errorf("failed to start mdns: %w", err)
+		}
+		s.endpoints[0].host = "qreph.local"
+		s.cleanup = append(s.cleanup, stop)
+		dnsNames = append(dnsNames, "qreph.local")
+	}
+	if s.opts.tls {
+		cert, err := selfSignedCert(s.opts.tlsKey, ips, dnsNames...)
+		if err != nil {
+			return fmt.Errorf("failed to generate certificate: %w", err)
+		}
+		s.cert = &cert
+		s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if s.opts.clientCA != nil {
+			s.opts.clientCA.tlsConfig(s.tlsConfig)
+		}
+		s.listener = tls.NewListener(s.listener, s.tlsConfig)
+		s.scheme = "https"
+	} else if s.opts.clientCA != nil {
+		return errors.New("client certificates need TLS")
+	}
+	if s.opts.http3 {
+		return s.listenHTTP3()
+	}
+	return nil
+}
+
+// Cert returns the self-signed certificate with WithTLS, or nil.
+func (s *Server) Cert() *tls.Certificate {
+	return s.cert
+}
+
+// URLs returns the URL of path under every advertised address. names label
+// them when there are several.
+func (s *Server) URLs(path string) (names, urls []string) {
+	for _, e := range s.endpoints {
+		names = append(names, e.name)
+		urls = append(urls, s.url(e, path))
+	}
+	return names, urls
+}
+
+func (s *Server) url(e endpoint, path string) string {
+	if s.opts.publicURL != "" {
+		return strings.TrimSuffix(s.opts.publicURL, "/") + path
+	}
+	port := e.port
+	if port == 0 {
+		port = s.listener.Addr().(*net.TCPAddr).Port
+	}
+	return fmt.Sprintf("%s://%s:%d%s", s.scheme, e.host, port, path)
+}
+
+// Router is a handler that can tell which of its routes a request is for,
+// like *http.ServeMux. Serve uses it to tell hits from misses in the
+// access log.
+type Router interface {
+	http.Handler
+	Handler(r *http.Request) (h http.Handler, pattern string)
+}
+
+// Serve answers requests with handler until Shutdown, or until the
+// WithTTL duration has passed.
+func (s *Server) Serve(handler http.Handler) error {
+	mux, _ := handler.(Router)
+	if s.links != nil {
+		handler = &linkRouter{signer: s.links, next: handler, mux: mux}
+		mux = handler.(Router)
+	}
+	if s.opts.rate > 0 {
+		handler = limitRate(&rateLimiter{bytesPerSec: s.opts.rate}, handler)
+	}
+	if len(s.opts.allow) > 0 {
+		handler = allowPeers(s.opts.allow, handler)
+	}
+	if s.lanNets != nil {
+		handler = allowPeers(s.lanNets, handler)
+	}
+	if _, tcp := s.listener.Addr().(*net.TCPAddr); s.opts.hostCheck && tcp {
+		handler = checkHost(s.hosts(), handler)
+	}
+	if s.opts.perMinute > 0 || s.opts.banAfter > 0 {
+		g := &peerGuard{perMinute: s.opts.perMinute, banAfter: s.opts.banAfter, peers: map[string]*peerState{}}
+		handler = s.guardPeers(g, mux, handler)
+	}
+	if s.opts.accessLog != nil {
+		handler = accessLog(s.opts.accessLog, mux, handler)
+		s.httpServer.ConnState = logConns(s.opts.accessLog)
+	}
+	if s.h3 != nil {
+		handler = s.serveHTTP3(handler)
+	}
+	if ttl := s.opts.ttl; ttl > 0 {
+		timer := time.AfterFunc(ttl, func() {
+			s.opts.logger.Printf("expired after %s, shutting down", ttl)
+			close(s.expired)
+			s.Shutdown()
+		})
+		defer timer.Stop()
+	}
+	s.httpServer.Handler = handler
+	if err := s.httpServer.Serve(s.listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Expired is closed when the server shut itself down after WithTTL.
+func (s *Server) Expired() <-chan struct{} {
+	return s.expired
+}
+
+// Shutdown lets requests in flight finish for a few seconds and then
+// closes the server.
+func (s *Server) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := s.httpServer.Shutdown(ctx)
+	if s.h3 != nil {
+		s.h3.Shutdown(ctx)
+	}
+	s.Close()
+	return err
+}
+
+// Close stops listening and undoes port forwards and mDNS.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		s.listener.Close()
+		for _, f := range s.cleanup {
+			f()
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package share
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Store holds a note until it has been fetched as often as allowed.
+type Store struct {
+	note       *Note
+	held       *Note // to wipe, even after the last fetch
+	remaining  int
+	keep       bool
+	burned     bool
+	mu         sync.Mutex
+	compress   bool
+	logger     *log.Logger
+	onDelivery func(Delivery)
+	fetches    int
+
+	// Used by serveContent for in-memory notes.
+	deliver   sync.Mutex
+	resumes   map[string]*resume // by resumeKey
+	etagOnce  sync.Once
+	etagValue string
+}
+
+// Delivery describes a completed fetch of a note.
+type Delivery struct {
+	Time      time.Time
+	Peer      string // IP address of the client
+	UserAgent string
+	Bytes     int64 // sent in the response that completed the fetch
+}
+
+// NewStore holds n for as many fetches as WithMaxDownloads allows. It also
+// takes WithLogger, WithCompression and WithOnDelivery.
+func NewStore(n *Note, opts ...Option) *Store {
+	c := newConfig(opts)
+	return &Store{note: n, held: n, remaining: c.maxDownloads, keep: c.maxDownloads <= 0, compress: c.compress, logger: c.logger, onDelivery: c.onDelivery}
+}
+
+// Get hands out the note until it has been fetched as often as allowed.
+// last reports whether this fetch used up the final one.
+func (s *Store) Get() (n *Note, last bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.note == nil {
+		return nil, false
+	}
+	n = s.note
+	if s.keep {
+		return n, false
+	}
+	s.remaining--
+	if s.remaining <= 0 {
+		s.note = nil
+		return n, true
+	}
+	return n, false
+}
+
+// Peek returns the note without counting it as fetched.
+func (s *Store) Peek() *Note {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.note
+}
+
+// Release undoes a Get whose delivery failed.
+func (s *Store) Release(n *Note) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.burned || s.keep:
+	case s.note == nil:
+		s.note, s.remaining = n, 1
+	default:
+		s.remaining++
+	}
+}
+
+// Burn drops the note, overwrites its content and reports whether it was
+// still there. Call it once the note is done with, fetched or not.
+func (s *Store) Burn() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	had := s.note != nil
+	s.note = nil
+	s.burned = true
+	if s.held != nil {
+		s.held.Wipe()
+		s.held = nil
+	}
+	return had
+}
+
+// Fetches returns how often the note has been fetched completely.
+func (s *Store) Fetches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+// report counts a completed fetch of the note and passes it to
+// WithOnDelivery.
+func (s *Store) report(r *http.Request, bytes int) {
+	s.mu.Lock()
+	s.fetches++
+	s.mu.Unlock()
+	if s.onDelivery == nil {
+		return
+	}
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	s.onDelivery(Delivery{Time: time.Now(), Peer: peer, UserAgent: r.UserAgent(), Bytes: int64(bytes)})
+}
@@ -0,0 +1,298 @@
+package share
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var quiet = WithLogger(log.New(io.Discard, "", 0))
+
+func TestStoreGet(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxDownloads int
+		want         []bool // last, for each Get that hands out the note
+	}{
+		{"once", 1, []bool{true}},
+		{"three times", 3, []bool{false, false, true}},
+		{"keep", 0, []bool{false, false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &Note{Content: []byte("hello")}
+			s := NewStore(n, WithMaxDownloads(tt.maxDownloads), quiet)
+			for i, want := range tt.want {
+				got, last := s.Get()
+				if got != n || last != want {
+					t.Fatalf("Get %d = %v, %v, want the note, %v", i+1, got, last, want)
+				}
+			}
+			if tt.maxDownloads > 0 {
+				if got, _ := s.Get(); got != nil {
+					t.Fatalf("Get after the last = %v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreRelease(t *testing.T) {
+	n := &Note{Content: []byte("hello")}
+	s := NewStore(n, quiet)
+	s.Get()
+	s.Release(n)
+	if got, last := s.Get(); got != n || !last {
+		t.Fatalf("Get after Release = %v, %v", got, last)
+	}
+	s.Release(n)
+	s.Burn()
+	s.Release(n)
+	if got, _ := s.Get(); got != nil {
+		t.Fatalf("Get after Burn and Release = %v", got)
+	}
+}
+
+func TestStoreBurn(t *testing.T) {
+	for _, maxDownloads := range []int{1, 0} {
+		t.Run(fmt.Sprint(maxDownloads), func(t *testing.T) {
+			n := &Note{Content: []byte("hello")}
+			s := NewStore(n, WithMaxDownloads(maxDownloads), quiet)
+			if !s.Burn() {
+				t.Fatal("Burn did not find the note")
+			}
+			if string(n.Content) != "\x00\x00\x00\x00\x00" {
+				t.Fatalf("content after Burn = %q", n.Content)
+			}
+			if s.Burn() {
+				t.Fatal("second Burn found the note")
+			}
+			if got := s.Peek(); got != nil {
+				t.Fatalf("Peek after Burn = %v", got)
+			}
+		})
+	}
+}
+
+// The note is wiped even if the last fetch already took it out.
+func TestStoreBurnAfterLastFetch(t *testing.T) {
+	n := &Note{Content: []byte("hello")}
+	s := NewStore(n, quiet)
+	s.Get()
+	if s.Burn() {
+		t.Fatal("Burn found a fetched note")
+	}
+	if string(n.Content) != "\x00\x00\x00\x00\x00" {
+		t.Fatalf("content after Burn = %q", n.Content)
+	}
+}
+
+func TestStoreDelivered(t *testing.T) {
+	type step struct {
+		client         string
+		sent           span
+		complete, done bool
+	}
+	tests := []struct {
+		name         string
+		maxDownloads int
+		steps        []step
+	}{
+		{"whole", 1, []step{
+			{"a", span{0, 5}, true, true},
+			{"a", span{0, 5}, false, true},
+		}},
+		{"resumed", 1, []step{
+			{"a", span{0, 3}, false, false},
+			{"a", span{3, 5}, true, true},
+		}},
+		{"overlapping", 1, []step{
+			{"a", span{2, 4}, false, false},
+			{"a", span{0, 3}, false, false},
+			{"a", span{3, 5}, true, true},
+		}},
+		{"claimed", 1, []step{
+			{"a", span{0, 3}, false, false},
+			{"b", span{3, 5}, false, false},
+			{"b", span{0, 3}, false, false},
+			{"a", span{3, 5}, true, true},
+		}},
+		{"twice", 2, []step{
+			{"a", span{0, 5}, true, false},
+			{"a", span{0, 2}, false, false},
+			{"b", span{0, 5}, false, false},
+			{"a", span{2, 5}, true, true},
+		}},
+		{"twice at once", 2, []step{
+			{"a", span{0, 2}, false, false},
+			{"b", span{0, 5}, true, false},
+			{"c", span{0, 5}, false, false},
+			{"a", span{2, 5}, true, true},
+		}},
+		{"keep whole", 0, []step{
+			{"a", span{0, 5}, true, false},
+			{"b", span{0, 5}, true, false},
+		}},
+		{"keep by client", 0, []step{
+			{"a", span{0, 3}, false, false},
+			{"b", span{3, 5}, false, false},
+			{"b", span{0, 1}, false, false},
+			{"a", span{3, 5}, true, false},
+			{"a", span{3, 5}, false, false},
+			{"b", span{1, 3}, true, false},
+		}},
+		{"keep same part twice", 0, []step{
+			{"a", span{0, 3}, false, false},
+			{"a", span{0, 3}, false, false},
+			{"a", span{3, 5}, true, false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &Note{Content: []byte("hello")}
+			s := NewStore(n, WithMaxDownloads(tt.maxDownloads), quiet)
+			done := make(chan struct{})
+			for i, st := range tt.steps {
+				// A client that may not be sent the note gets nothing to
+				// record.
+				if s.peekFor(st.client) == nil {
+					if st.complete {
+						t.Fatalf("step %d: %s may not fetch", i+1, st.client)
+					}
+					continue
+				}
+				if complete := s.delivered(n, st.client, st.sent, done); complete != st.complete {
+					t.Fatalf("step %d: delivered(%s, %v) = %v, want %v", i+1, st.client, st.sent, complete, st.complete)
+				}
+				if closed(done) != st.done {
+					t.Fatalf("step %d: done closed is %v, want %v", i+1, closed(done), st.done)
+				}
+			}
+		})
+	}
+}
+
+func closed(done chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// A fetch claimed and not finished is used up once its window is over.
+func TestStoreAbandon(t *testing.T) {
+	n := &Note{Content: []byte("hello")}
+	s := NewStore(n, quiet)
+	done := make(chan struct{})
+	s.delivered(n, "a", span{0, 4}, done)
+	s.abandon(n, "a", s.resumes["a"], done)
+	if !closed(done) {
+		t.Fatal("done not closed")
+	}
+	for _, client := range []string{"a", "b"} {
+		if got := s.peekFor(client); got != nil {
+			t.Fatalf("peekFor(%s) after abandon = %v", client, got)
+		}
+	}
+	// A later timer for a claim that was finished does nothing.
+	s = NewStore(n, WithMaxDownloads(2), quiet)
+	done = make(chan struct{})
+	s.delivered(n, "a", span{0, 4}, done)
+	r := s.resumes["a"]
+	s.delivered(n, "a", span{4, 5}, done)
+	s.delivered(n, "a", span{0, 4}, done)
+	s.abandon(n, "a", r, done)
+	if closed(done) || s.resumes["a"] == nil {
+		t.Fatal("a stale timer ended the second fetch")
+	}
+}
+
+func TestStoreDeliveredMaxResumes(t *testing.T) {
+	n := &Note{Content: []byte("hello")}
+	s := NewStore(n, WithMaxDownloads(0), quiet)
+	done := make(chan struct{})
+	for i := range maxResumes {
+		s.delivered(n, fmt.Sprint(i), span{0, 3}, done)
+	}
+	if !s.delivered(n, "0", span{3, 5}, done) {
+		t.Fatal("a tracked client did not complete")
+	}
+	s.delivered(n, "new", span{0, 3}, done)
+	s.delivered(n, "other", span{0, 3}, done)
+	if s.delivered(n, "1", span{3, 5}, done) {
+		t.Fatal("a client forgotten past maxResumes completed")
+	}
+	if len(s.resumes) > maxResumes {
+		t.Fatalf("tracking %d clients", len(s.resumes))
+	}
+}
+
+func TestServeContentRange(t *testing.T) {
+	type fetch struct {
+		peer, rng string
+		status    int
+		body      string
+	}
+	tests := []struct {
+		name         string
+		maxDownloads int
+		fetches      []fetch
+		want         int // fetches counted
+		done         bool
+	}{
+		{"whole", 1, []fetch{
+			{"192.0.2.1", "", http.StatusOK, "hello"},
+			{"192.0.2.1", "", http.StatusNotFound, ""},
+		}, 1, true},
+		{"resumed", 1, []fetch{
+			{"192.0.2.1", "bytes=0-2", http.StatusPartialContent, "hel"},
+			{"192.0.2.1", "bytes=3-", http.StatusPartialContent, "lo"},
+		}, 1, true},
+		{"prefix for another client", 1, []fetch{
+			{"192.0.2.1", "bytes=0-3", http.StatusPartialContent, "hell"},
+			{"192.0.2.2", "bytes=0-3", http.StatusNotFound, ""},
+			{"192.0.2.2", "", http.StatusNotFound, ""},
+			{"192.0.2.1", "bytes=4-", http.StatusPartialContent, "o"},
+			{"192.0.2.2", "", http.StatusNotFound, ""},
+		}, 1, true},
+		{"keep partial", 0, []fetch{
+			{"192.0.2.1", "bytes=0-2", http.StatusPartialContent, "hel"},
+			{"192.0.2.1", "bytes=0-2", http.StatusPartialContent, "hel"},
+			{"192.0.2.2", "bytes=3-", http.StatusPartialContent, "lo"},
+		}, 0, false},
+		{"keep resumed", 0, []fetch{
+			{"192.0.2.1", "bytes=0-2", http.StatusPartialContent, "hel"},
+			{"192.0.2.2", "", http.StatusOK, "hello"},
+			{"192.0.2.1", "bytes=3-", http.StatusPartialContent, "lo"},
+		}, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore(&Note{Content: []byte("hello")}, WithMaxDownloads(tt.maxDownloads), quiet)
+			done := make(chan struct{})
+			for i, f := range tt.fetches {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.RemoteAddr = f.peer + ":1234"
+				if f.rng != "" {
+					r.Header.Set("Range", f.rng)
+				}
+				w := httptest.NewRecorder()
+				serveContent(w, r, s, done)
+				if w.Code != f.status || (f.body != "" && w.Body.String() != f.body) {
+					t.Fatalf("fetch %d: got %d %q, want %d %q", i+1, w.Code, w.Body, f.status, f.body)
+				}
+			}
+			if got := s.Fetches(); got != tt.want {
+				t.Fatalf("Fetches = %d, want %d", got, tt.want)
+			}
+			if closed(done) != tt.done {
+				t.Fatalf("done closed is %v, want %v", closed(done), tt.done)
+			}
+		})
+	}
+}
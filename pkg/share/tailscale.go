@@ -0,0 +1,66 @@
+package share
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"tailscale.com/tsnet"
+)
+
+// listenTailnet joins the tailnet as a node of its own, named qreph, with
+// tsnet, so it needs no tailscaled on this machine and is reachable only
+// over the tailnet. The first run prints a login URL, unless $TS_AUTHKEY
+// holds an auth key; the node's state is kept in the user config directory,
+// so later runs join straight away.
+func listenTailnet(port int, logger *log.Logger) (l net.Listener, dnsName string, ip net.IP, stop func(), err error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	ts := &tsnet.Server{
+		Dir:      filepath.Join(dir, "qreph", "tsnet"),
+		Hostname: "qreph",
+		// Only the login URL out of the chatter.
+		UserLogf: func(format string, args ...any) {
+			msg := fmt.Sprintf(format, args...)
+			if i := strings.Index(msg, "go to: "); i >= 0 && strings.Contains(msg, "TS_AUTHKEY") {
+				logger.Printf("to add qreph to your tailnet, go to %s or set $TS_AUTHKEY", msg[i+len("go to: "):])
+			}
+		},
+	}
+	status, err := ts.Up(context.Background())
+	if err != nil {
+		ts.Close()
+		return nil, "", nil, nil, err
+	}
+	if status.Self == nil || len(status.TailscaleIPs) == 0 {
+		ts.Close()
+		return nil, "", nil, nil, errors.New("the tailnet gave the node no address")
+	}
+	ln, err := ts.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		ts.Close()
+		return nil, "", nil, nil, err
+	}
+	ip = net.IP(status.TailscaleIPs[0].AsSlice())
+	l = tailnetListener{Listener: ln, addr: &net.TCPAddr{IP: ip, Port: port}}
+	return l, strings.TrimSuffix(status.Self.DNSName, "."), ip, func() { ts.Close() }, nil
+}
+
+// tailnetListener gives a tsnet listener the TCP address the rest of the
+// server uses for URLs and the Host check.
+type tailnetListener struct {
+	net.Listener
+	addr *net.TCPAddr
+}
+
+func (l tailnetListener) Addr() net.Addr {
+	return l.addr
+}
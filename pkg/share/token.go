@@ -0,0 +1,22 @@
+package share
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// TokenHandler passes requests to next only if they carry token as a
+// Bearer token, so the URL alone is not enough. Others are refused and
+// leave the note alone.
+func TokenHandler(token string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="qreph"`)
+			http.Error(w, "this note needs the token shown on the sender's screen", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,179 @@
+package share
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Views are HTML pages that browsers get in place of the raw note. The raw
+// text is always on the page too, so the one fetch is enough for both.
+
+const (
+	ViewMarkdown = "markdown"
+	ViewCode     = "code"
+	ViewCopy     = "copy"
+	ViewSSHKey   = "ssh-key" // a copyable command adding the key to authorized_keys
+)
+
+var viewPage = template.Must(template.New("view").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>qreph</title>
+<style>
+body { font-family: sans-serif; margin: 2em; line-height: 1.5; overflow-wrap: break-word; }
+pre { background: #f4f4f4; padding: 0.5em; white-space: pre-wrap; word-break: break-all; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.5em; }
+#raw { display: none; }
+#raw:target { display: block; }
+nav { font-size: 0.9em; margin-bottom: 1em; }
+button { font-size: 1.5em; width: 100%; margin-bottom: 1em; }
+footer { color: #666; font-size: 0.8em; margin-top: 2em; word-break: break-all; }
+</style>
+</head>
+<body>
+{{if .Copy}}
+{{.Body}}
+<button id="copy">Copy</button>
+<pre id="text">{{.Copy}}</pre>
+<script>
+var button = document.getElementById("copy");
+button.addEventListener("click", function () {
+	var text = document.getElementById("text");
+	var done = function () { button.textContent = "Copied"; };
+	if (navigator.clipboard && window.isSecureContext) {
+		navigator.clipboard.writeText(text.textContent).then(done);
+		return;
+	}
+	// The async clipboard API needs HTTPS, select and copy the old way.
+	var range = document.createRange();
+	range.selectNodeContents(text);
+	getSelection().removeAllRanges();
+	getSelection().addRange(range);
+	if (document.execCommand("copy")) done();
+});
+</script>
+{{else}}
+<nav><a href="#raw">view raw</a></nav>
+<pre id="raw">{{.Raw}}</pre>
+<main>{{.Body}}</main>
+{{end}}
+{{if .Sum}}<footer>SHA-256: <code>{{.Sum}}</code></footer>{{end}}
+</body>
+</html>
+`))
+
+// DetectView picks a view for n from its type and name, and for code the
+// language.
+func DetectView(n *Note) (view, lang string) {
+	ext := strings.ToLower(filepath.Ext(n.Filename))
+	if strings.HasPrefix(n.ContentType, "text/markdown") || ext == ".md" || ext == ".markdown" {
+		return ViewMarkdown, ""
+	}
+	if n.Filename != "" && n.Stream == nil && !strings.Contains(http.DetectContentType(n.Content), "octet-stream") {
+		if l := lexers.Match(n.Filename); l != nil && l.Config().Name != "plaintext" {
+			return ViewCode, l.Config().Name
+		}
+	}
+	return "", ""
+}
+
+// CodeLexer finds the lexer for lang, guessing from content for "auto".
+func CodeLexer(lang string, content []byte) (chroma.Lexer, error) {
+	var l chroma.Lexer
+	if lang == "auto" {
+		l = lexers.Analyse(transient(content))
+	} else {
+		l = lexers.Get(lang)
+	}
+	if l == nil {
+		if lang == "auto" {
+			return lexers.Fallback, nil
+		}
+		return nil, fmt.Errorf("unknown language %q", lang)
+	}
+	return l, nil
+}
+
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// renderView returns the view page of n. The page and everything used to
+// build it that holds the content are wiped by the caller or before it
+// returns, so the template is handed strings that share those buffers.
+func renderView(n *Note, content []byte) ([]byte, error) {
+	raw := bytes.Clone(content)
+	defer clear(raw)
+	var body bytes.Buffer
+	defer func() { clear(body.Bytes()) }()
+	switch n.View {
+	case ViewMarkdown:
+		md := goldmark.New(goldmark.WithExtensions(extension.GFM))
+		if err := md.Convert(raw, &body); err != nil {
+			return nil, err
+		}
+	case ViewSSHKey:
+		body.WriteString("<p>Run this on the machine the key should log in to:</p>")
+	case ViewCode:
+		l, err := CodeLexer(n.Lang, raw)
+		if err != nil {
+			return nil, err
+		}
+		tokens, err := chroma.Coalesce(l).Tokenise(nil, transient(raw))
+		if err != nil {
+			return nil, err
+		}
+		if err := html.New(html.WithLineNumbers(true)).Format(&body, styles.Get("github"), tokens); err != nil {
+			return nil, err
+		}
+	}
+	var copyText string
+	switch n.View {
+	case ViewCopy:
+		copyText = transient(raw)
+	case ViewSSHKey:
+		// A public key, nothing to wipe.
+		copyText = authorizeKeyCommand(content)
+	}
+	var page bytes.Buffer
+	err := viewPage.Execute(&page, struct {
+		Raw  string
+		Body template.HTML
+		Copy string
+		Sum  string
+	}{transient(raw), template.HTML(transient(body.Bytes())), copyText, n.Sum})
+	if err != nil {
+		clear(page.Bytes())
+		return nil, err
+	}
+	return page.Bytes(), nil
+}
+
+// transient returns b as a string without copying it, for passing the note
+// to code that only takes strings and keeps none of them, so that clearing
+// b clears the string too. Nothing may hold on to the string after that.
+func transient(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
+
+// authorizeKeyCommand returns a shell command appending the public key in
+// content to ~/.ssh/authorized_keys.
+func authorizeKeyCommand(content []byte) string {
+	key := "'" + strings.ReplaceAll(strings.TrimSpace(string(content)), "'", `'\''`) + "'"
+	return "mkdir -p ~/.ssh && chmod 700 ~/.ssh && echo " + key + " >> ~/.ssh/authorized_keys && chmod 600 ~/.ssh/authorized_keys"
+}
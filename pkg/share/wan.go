@@ -0,0 +1,348 @@
+package share
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const portMappingLease = time.Hour
+
+// portMapping is a router port forward to this machine, kept alive until
+// stop is called.
+type portMapping struct {
+	externalIP   net.IP
+	externalPort int
+	stop         func()
+}
+
+type portMapper interface {
+	externalIP() (net.IP, error)
+	add(internalIP net.IP, internalPort int, lease time.Duration) (externalPort int, err error)
+	remove(internalPort, externalPort int) error
+}
+
+// mapPort asks the router for a forward to internalPort, trying NAT-PMP
+// first and UPnP IGD second.
+func mapPort(internalIP net.IP, internalPort int) (*portMapping, error) {
+	if internalIP.To4() == nil {
+		return nil, errors.New("port mapping needs an IPv4 address")
+	}
+	gw, err := defaultGateway(internalIP)
+	if err != nil {
+		return nil, err
+	}
+
+	mappers := []func() (portMapper, error){
+		func() (portMapper, error) { return &natPMP{gateway: gw}, nil },
+		func() (portMapper, error) { return discoverIGD() },
+	}
+
+	var errs []error
+	for _, newMapper := range mappers {
+		m, err := newMapper()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		extPort, err := m.add(internalIP, internalPort, portMappingLease)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		extIP, err := m.externalIP()
+		if err != nil {
+			m.remove(internalPort, extPort)
+			errs = append(errs, err)
+			continue
+		}
+		quit := make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(portMappingLease / 2)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					m.add(internalIP, internalPort, portMappingLease)
+				case <-quit:
+					return
+				}
+			}
+		}()
+		return &portMapping{
+			externalIP:   extIP,
+			externalPort: extPort,
+			stop: func() {
+				close(quit)
+				m.remove(internalPort, extPort)
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("router refused port mapping: %w", errors.Join(errs...))
+}
+
+// defaultGateway reads the IPv4 default route on Linux and otherwise guesses
+// the .1 address of the local /24.
+func defaultGateway(local net.IP) (net.IP, error) {
+	if f, err := os.Open("/proc/net/route"); err == nil {
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) < 3 || fields[1] != "00000000" {
+				continue
+			}
+			b, err := hex.DecodeString(fields[2])
+			if err != nil || len(b) != 4 {
+				continue
+			}
+			return net.IPv4(b[3], b[2], b[1], b[0]), nil
+		}
+	}
+	ip4 := local.To4()
+	return net.IPv4(ip4[0], ip4[1], ip4[2], 1), nil
+}
+
+// natPMP speaks RFC 6886 to the gateway.
+type natPMP struct {
+	gateway net.IP
+}
+
+func (p *natPMP) call(req []byte, respLen int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: p.gateway, Port: 5351})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	resp := make([]byte, 16)
+	timeout := 250 * time.Millisecond
+	for range 4 {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(resp)
+		if err != nil {
+			timeout *= 2
+			continue
+		}
+		if n < respLen || resp[1] != req[1]+128 {
+			return nil, errors.New("nat-pmp: malformed response")
+		}
+		if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+			return nil, fmt.Errorf("nat-pmp: result code %d", code)
+		}
+		return resp[:n], nil
+	}
+	return nil, errors.New("nat-pmp: no response from gateway")
+}
+
+func (p *natPMP) externalIP() (net.IP, error) {
+	resp, err := p.call([]byte{0, 0}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+func (p *natPMP) mapTCP(internalPort, externalPort int, lease time.Duration) (int, error) {
+	req := make([]byte, 12)
+	req[1] = 2 // map TCP
+	binary.BigEndian.PutUint16(req[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(req[6:8], uint16(externalPort))
+	binary.BigEndian.PutUint32(req[8:12], uint32(lease.Seconds()))
+	resp, err := p.call(req, 16)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(resp[10:12])), nil
+}
+
+func (p *natPMP) add(_ net.IP, internalPort int, lease time.Duration) (int, error) {
+	return p.mapTCP(internalPort, internalPort, lease)
+}
+
+func (p *natPMP) remove(internalPort, _ int) error {
+	_, err := p.mapTCP(internalPort, 0, 0)
+	return err
+}
+
+// upnpIGD drives the WANIPConnection service of a UPnP internet gateway.
+type upnpIGD struct {
+	controlURL  string
+	serviceType string
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+func (d *upnpDevice) find() (serviceType, controlURL string) {
+	for _, s := range d.Services {
+		if strings.Contains(s.ServiceType, ":WANIPConnection:") || strings.Contains(s.ServiceType, ":WANPPPConnection:") {
+			return s.ServiceType, s.ControlURL
+		}
+	}
+	for i := range d.Devices {
+		if t, u := d.Devices[i].find(); u != "" {
+			return t, u
+		}
+	}
+	return "", ""
+}
+
+func discoverIGD() (*upnpIGD, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+	if _, err := conn.WriteToUDP([]byte(search), &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, errors.New("upnp: no internet gateway found")
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		if igd, err := loadIGD(resp.Header.Get("Location")); err == nil {
+			return igd, nil
+		}
+	}
+}
+
+func loadIGD(location string) (*upnpIGD, error) {
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&root); err != nil {
+		return nil, err
+	}
+	serviceType, control := root.Device.find()
+	if control == "" {
+		return nil, errors.New("upnp: gateway has no WAN connection service")
+	}
+	if root.URLBase != "" {
+		if b, err := url.Parse(root.URLBase); err == nil {
+			base = b
+		}
+	}
+	ref, err := url.Parse(control)
+	if err != nil {
+		return nil, err
+	}
+	return &upnpIGD{controlURL: base.ResolveReference(ref).String(), serviceType: serviceType}, nil
+}
+
+func (g *upnpIGD) soap(action string, args [][2]string) ([]byte, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, g.serviceType)
+	for _, a := range args {
+		fmt.Fprintf(&body, "<%s>", a[0])
+		xml.EscapeText(&body, []byte(a[1]))
+		fmt.Fprintf(&body, "</%s>", a[0])
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequest(http.MethodPost, g.controlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, g.serviceType, action))
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upnp: %s failed: %s", action, resp.Status)
+	}
+	return data, nil
+}
+
+func (g *upnpIGD) externalIP() (net.IP, error) {
+	data, err := g.soap("GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(resp.IP))
+	if ip == nil {
+		return nil, errors.New("upnp: gateway returned no external address")
+	}
+	return ip, nil
+}
+
+func (g *upnpIGD) add(internalIP net.IP, internalPort int, lease time.Duration) (int, error) {
+	port := strconv.Itoa(internalPort)
+	_, err := g.soap("AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", port},
+		{"NewProtocol", "TCP"},
+		{"NewInternalPort", port},
+		{"NewInternalClient", internalIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", "qreph"},
+		{"NewLeaseDuration", strconv.Itoa(int(lease.Seconds()))},
+	})
+	return internalPort, err
+}
+
+func (g *upnpIGD) remove(_, externalPort int) error {
+	_, err := g.soap("DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(externalPort)},
+		{"NewProtocol", "TCP"},
+	})
+	return err
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base32"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Presets build the payloads phones understand natively and show them as
+// direct QR codes, without a server.
+
+// prompt asks for a value on stderr and reads one line of stdin, so secrets
+// stay out of the shell history.
+func prompt(label string) string {
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatalf("failed to read %s: %v", strings.ToLower(label), err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// wifiEscaper escapes the characters that are special in the MECARD-like
+// syntax of WIFI: payloads.
+var wifiEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`)
+
+func wifi(args []string) {
+	fs := flag.NewFlagSet("wifi", flag.ExitOnError)
+	ssid := fs.String("ssid", "", "network `name`")
+	pass := fs.String("pass", "", "network `password` (prompted for if empty)")
+	security := fs.String("type", "WPA", "security `type` WPA, WEP or nopass (WPA also covers WPA2 and WPA3)")
+	hidden := fs.Bool("hidden", false, "the network does not broadcast its name")
+	qrOpts := addQRFlags(fs)
+	qrOpts.addPaperFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph wifi --ssid name [--pass password] [--type WPA|WEP|nopass] [--hidden]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *ssid == "" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	*security = strings.ToUpper(*security)
+	switch *security {
+	case "WPA", "WEP":
+		if *pass == "" {
+			p, err := readPassword("Password")
+			if err != nil {
+				log.Fatalf("failed to read the password: %v", err)
+			}
+			*pass = string(p)
+		}
+	case "NOPASS":
+		*security, *pass = "nopass", ""
+	default:
+		log.Fatalf("unknown security type %q", *security)
+	}
+
+	payload := "WIFI:T:" + *security + ";S:" + wifiEscaper.Replace(*ssid) + ";"
+	if *pass != "" {
+		payload += "P:" + wifiEscaper.Replace(*pass) + ";"
+	}
+	if *hidden {
+		payload += "H:true;"
+	}
+	qrOpts.showDirect(fmt.Sprintf("Scan to join %s:", *ssid), payload+";")
+}
+
+var vcardEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `;`, `\;`, "\n", `\n`)
+
+func vcard(args []string) {
+	fs := flag.NewFlagSet("vcard", flag.ExitOnError)
+	name := fs.String("name", "", "full `name` of the contact")
+	var phones, emails []string
+	fs.Func("phone", "phone `number` (repeatable)", func(s string) error {
+		phones = append(phones, s)
+		return nil
+	})
+	fs.Func("email", "email `address` (repeatable)", func(s string) error {
+		emails = append(emails, s)
+		return nil
+	})
+	org := fs.String("org", "", "`organization`")
+	title := fs.String("title", "", "job `title`")
+	site := fs.String("url", "", "website `url`")
+	qrOpts := addQRFlags(fs)
+	qrOpts.addPaperFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph vcard --name name [--phone number] [--email address] [--org org] [--title title] [--url url]")
+		fmt.Fprintln(fs.Output(), "       qreph vcard < contact.vcf")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	var card string
+	if stdinPiped() && *name == "" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("failed to read from stdin: %v", err)
+		}
+		card = strings.TrimSpace(string(data))
+		if !strings.HasPrefix(strings.ToUpper(card), "BEGIN:VCARD") {
+			log.Fatal("stdin is not a vCard")
+		}
+	} else {
+		if *name == "" {
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		given, family := *name, ""
+		if i := strings.LastIndex(*name, " "); i >= 0 {
+			given, family = (*name)[:i], (*name)[i+1:]
+		}
+		lines := []string{
+			"BEGIN:VCARD",
+			"VERSION:3.0",
+			"N:" + vcardEscaper.Replace(family) + ";" + vcardEscaper.Replace(given) + ";;;",
+			"FN:" + vcardEscaper.Replace(*name),
+		}
+		if *org != "" {
+			lines = append(lines, "ORG:"+vcardEscaper.Replace(*org))
+		}
+		if *title != "" {
+			lines = append(lines, "TITLE:"+vcardEscaper.Replace(*title))
+		}
+		for _, p := range phones {
+			lines = append(lines, "TEL:"+vcardEscaper.Replace(p))
+		}
+		for _, e := range emails {
+			lines = append(lines, "EMAIL:"+vcardEscaper.Replace(e))
+		}
+		if *site != "" {
+			lines = append(lines, "URL:"+vcardEscaper.Replace(*site))
+		}
+		card = strings.Join(append(lines, "END:VCARD"), "\r\n")
+	}
+	qrOpts.showDirect("Scan to add the contact:", card)
+}
+
+func totp(args []string) {
+	fs := flag.NewFlagSet("totp", flag.ExitOnError)
+	secret := fs.String("secret", "", "base32 `secret` (read from stdin or prompted for if empty)")
+	issuer := fs.String("issuer", "", "service `name` shown in the authenticator app")
+	account := fs.String("account", "", "account `name`, e.g. an email address")
+	algorithm := fs.String("algorithm", "SHA1", "hash `algorithm` SHA1, SHA256 or SHA512")
+	digits := fs.Int("digits", 6, "`n` digits per code")
+	period := fs.Int("period", 30, "`seconds` per code")
+	qrOpts := addQRFlags(fs)
+	qrOpts.addPaperFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph totp --issuer name [--account name] [--secret base32]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *issuer == "" && *account == "" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if *secret == "" {
+		if stdinPiped() {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatalf("failed to read from stdin: %v", err)
+			}
+			*secret = string(data)
+		} else {
+			p, err := readPassword("Secret")
+			if err != nil {
+				log.Fatalf("failed to read the secret: %v", err)
+			}
+			*secret = string(p)
+		}
+	}
+	*secret = strings.ToUpper(strings.Join(strings.Fields(*secret), ""))
+	*secret = strings.TrimRight(*secret, "=")
+	if _, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(*secret); err != nil || *secret == "" {
+		log.Fatal("secret is not valid base32")
+	}
+	*algorithm = strings.ToUpper(*algorithm)
+	if *algorithm != "SHA1" && *algorithm != "SHA256" && *algorithm != "SHA512" {
+		log.Fatalf("unknown algorithm %q", *algorithm)
+	}
+
+	label := *account
+	if *issuer != "" && *account != "" {
+		label = *issuer + ":" + *account
+	} else if *issuer != "" {
+		label = *issuer
+	}
+	q := url.Values{"secret": {*secret}, "algorithm": {*algorithm}, "digits": {strconv.Itoa(*digits)}, "period": {strconv.Itoa(*period)}}
+	if *issuer != "" {
+		q.Set("issuer", *issuer)
+	}
+	// Authenticator apps show a "+" in the issuer literally.
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: strings.ReplaceAll(q.Encode(), "+", "%20")}
+	qrOpts.showDirect("Scan to add the account to an authenticator app:", u.String())
+}
+
+// geo shows a geo: URI, which phones open in their map app. An address
+// goes in the q parameter, for the app to search.
+func geo(args []string) {
+	fs := flag.NewFlagSet("geo", flag.ExitOnError)
+	label := fs.String("label", "", "show the coordinates as a pin called `name`")
+	qrOpts := addQRFlags(fs)
+	qrOpts.addPaperFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph geo [--label name] <lat,lon | address>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	place := strings.Join(fs.Args(), " ")
+	var uri string
+	if lat, lon, ok := parseCoordinates(place); ok {
+		if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			log.Fatal("latitude must be within ±90 and longitude within ±180")
+		}
+		point := strconv.FormatFloat(lat, 'f', -1, 64) + "," + strconv.FormatFloat(lon, 'f', -1, 64)
+		uri = "geo:" + point
+		if *label != "" {
+			uri += "?q=" + point + "(" + queryEscape(*label) + ")"
+		}
+	} else {
+		if *label != "" {
+			log.Fatal("--label only applies to coordinates")
+		}
+		uri = "geo:0,0?q=" + queryEscape(place)
+	}
+	qrOpts.showDirect("Scan to open the map:", uri)
+}
+
+// parseCoordinates reads "lat,lon" in decimal degrees.
+func parseCoordinates(s string) (lat, lon float64, ok bool) {
+	a, b, found := strings.Cut(s, ",")
+	if !found {
+		return 0, 0, false
+	}
+	lat, err1 := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	lon, err2 := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	return lat, lon, err1 == nil && err2 == nil
+}
+
+// queryEscape escapes s for a URI query with %20 for spaces, which map apps
+// read more reliably than +.
+func queryEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// phoneNumber drops the spaces, dashes, dots and parentheses people write
+// numbers with, and rejects anything else that is not a digit or a leading
+// +.
+func phoneNumber(s string) (string, error) {
+	number := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" -.()", r) {
+			return -1
+		}
+		return r
+	}, s)
+	for i, r := range number {
+		if (r < '0' || r > '9') && (r != '+' || i != 0) {
+			return "", fmt.Errorf("%q is not a phone number", s)
+		}
+	}
+	if strings.TrimPrefix(number, "+") == "" {
+		return "", fmt.Errorf("%q is not a phone number", s)
+	}
+	return number, nil
+}
+
+// tel shows a tel: URI, which phones offer to call.
+func tel(args []string) {
+	fs := flag.NewFlagSet("tel", flag.ExitOnError)
+	qrOpts := addQRFlags(fs)
+	qrOpts.addPaperFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph tel <number>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	number, err := phoneNumber(strings.Join(fs.Args(), " "))
+	if err != nil {
+		log.Fatal(err)
+	}
+	qrOpts.showDirect("Scan to call "+number+":", "tel:"+number)
+}
+
+// sms shows an sms: URI, which phones open as a new message.
+func sms(args []string) {
+	fs := flag.NewFlagSet("sms", flag.ExitOnError)
+	body := fs.String("body", "", "message `text` to fill in")
+	qrOpts := addQRFlags(fs)
+	qrOpts.addPaperFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph sms [--body text] <number>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	number, err := phoneNumber(strings.Join(fs.Args(), " "))
+	if err != nil {
+		log.Fatal(err)
+	}
+	uri := "sms:" + number
+	if *body != "" {
+		uri += "?body=" + queryEscape(*body)
+	}
+	qrOpts.showDirect("Scan to text "+number+":", uri)
+}
+
+// mailto shows a mailto: URI, which phones open as a new email.
+func mailto(args []string) {
+	fs := flag.NewFlagSet("mailto", flag.ExitOnError)
+	subject := fs.String("subject", "", "subject `line` to fill in")
+	body := fs.String("body", "", "message `text` to fill in")
+	cc := fs.String("cc", "", "also address the email to `addresses`, separated by commas")
+	qrOpts := addQRFlags(fs)
+	qrOpts.addPaperFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph mailto [--subject line] [--body text] [--cc addresses] <address>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 || !strings.Contains(fs.Arg(0), "@") {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	var q []string
+	for _, p := range []struct{ key, value string }{{"cc", *cc}, {"subject", *subject}, {"body", *body}} {
+		if p.value != "" {
+			q = append(q, p.key+"="+queryEscape(p.value))
+		}
+	}
+	uri := "mailto:" + url.PathEscape(fs.Arg(0))
+	if len(q) > 0 {
+		uri += "?" + strings.Join(q, "&")
+	}
+	qrOpts.showDirect("Scan to email "+fs.Arg(0)+":", uri)
+}
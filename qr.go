@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/kevinkokinda/qreph/pkg/qr"
+)
+
+type qrOptions struct {
+	qr.Renderer
+	out    string
+	paper  string // with addPaperFlag
+	noCopy bool
+	copied bool
+	noQR   bool // print the URL alone
+	qrOnly bool // print the code alone
+	swap   bool // the URL goes to stderr and the rest to stdout
+	json   bool // stdout is taken by --json
+}
+
+func addQRFlags(fs *flag.FlagSet) *qrOptions {
+	opts := &qrOptions{Renderer: qr.Renderer{Level: qr.L}}
+	fs.Func("ec", "QR error correction `level` L, M, Q or H; higher levels survive glare and occlusion but make bigger codes (default L)", func(s string) error {
+		i := strings.Index("LMQH", strings.ToUpper(s))
+		if len(s) != 1 || i < 0 {
+			return errors.New("want L, M, Q or H")
+		}
+		opts.Level = qr.Level(i)
+		return nil
+	})
+	fs.StringVar(&opts.out, "qr-out", "", "also write the QR code to `file` (.png or .svg)")
+	fs.StringVar(&opts.Graphics, "qr-graphics", "auto", "draw the QR code as an image with the `protocol` sixel, kitty or iterm2, or none for text only")
+	fs.BoolFunc("no-qr", "print just the URL, without label or QR code, for piping", func(s string) (err error) {
+		if opts.noQR, err = strconv.ParseBool(s); opts.noQR && opts.qrOnly {
+			return errors.New("--no-qr and --qr-only are mutually exclusive")
+		}
+		return err
+	})
+	fs.BoolFunc("qr-only", "print just the QR code, without the URL or details, for screenshots", func(s string) (err error) {
+		if opts.qrOnly, err = strconv.ParseBool(s); opts.noQR && opts.qrOnly {
+			return errors.New("--no-qr and --qr-only are mutually exclusive")
+		}
+		return err
+	})
+	fs.BoolVar(&opts.swap, "swap-output", false, "print the URL on stderr and the QR code and details on stdout")
+	fs.BoolVar(&opts.noCopy, "no-copy", false, "do not put the URL on the system clipboard")
+	fs.BoolVar(&opts.Compact, "compact", false, "draw the text QR code with half blocks at half the height (default when the full size does not fit)")
+	fs.BoolFunc("invert", "swap dark and light modules in the text QR code, for light terminal backgrounds (default: guessed from $COLORFGBG)", func(s string) error {
+		v, err := strconv.ParseBool(s)
+		opts.Invert = &v
+		return err
+	})
+	return opts
+}
+
+// show prints label and url with a QR code on the terminal and, with
+// --qr-out, writes it to a file. name distinguishes the file when several
+// URLs are shown.
+func (o *qrOptions) show(label, url, name string) {
+	human, data := o.streams()
+	switch {
+	case o.qrOnly:
+	case human == data && !o.noQR:
+		fmt.Fprintln(data, label, url)
+	case human != data && !o.noQR:
+		fmt.Fprintln(human, label)
+		fallthrough
+	default:
+		fmt.Fprintln(data, url)
+	}
+	o.draw(url, name)
+	o.copy(url)
+}
+
+// copy puts the first URL shown on the clipboard.
+func (o *qrOptions) copy(url string) {
+	if !o.noCopy && !o.copied {
+		// Best effort, there is often no clipboard over SSH.
+		writeClipboard([]byte(url))
+		o.copied = true
+	}
+}
+
+// showDirect draws text itself as the QR code, for payloads that need no
+// server.
+func (o *qrOptions) showDirect(label, text string) {
+	if err := qr.Fits(text, o.Level); err != nil {
+		log.Fatalf("content does not fit in a QR code: %v", err)
+	}
+	human, data := o.streams()
+	switch {
+	case o.noQR:
+		fmt.Fprintln(data, text)
+	case !o.qrOnly:
+		fmt.Fprintln(human, label)
+	}
+	o.draw(text, "")
+	o.writePaper(paperPage(strings.TrimSuffix(label, ":"), text))
+}
+
+// streams returns where labels, codes and details go and where the URL
+// goes. They are both stdout on a terminal, otherwise only the URL is, so
+// that it can be piped on.
+func (o *qrOptions) streams() (human, data *os.File) {
+	switch {
+	case o.swap:
+		return os.Stdout, os.Stderr
+	case o.json || !term.IsTerminal(int(os.Stdout.Fd())):
+		return os.Stderr, os.Stdout
+	}
+	return os.Stdout, os.Stdout
+}
+
+// human returns where labels, codes and details go.
+func (o *qrOptions) human() *os.File {
+	human, _ := o.streams()
+	return human
+}
+
+func (o *qrOptions) draw(text, name string) {
+	if !o.noQR {
+		if err := o.Render(o.human(), text); err != nil {
+			fatalf("failed to draw QR code: %v", err)
+		}
+	}
+	o.save(text, name)
+}
+
+// save writes the QR code for text to --qr-out.
+func (o *qrOptions) save(text, name string) {
+	if o.out == "" {
+		return
+	}
+	path := o.out
+	if name != "" {
+		ext := filepath.Ext(path)
+		path = strings.TrimSuffix(path, ext) + "-" + name + ext
+	}
+	if err := qr.WriteImage(path, text, o.Level); err != nil {
+		fatalf("failed to write QR code: %v", err)
+	}
+}
+
+// qrCommand draws text from the arguments or stdin as a QR code.
+func qrCommand(args []string) {
+	fs := flag.NewFlagSet("qr", flag.ExitOnError)
+	opts := addQRFlags(fs)
+	opts.addPaperFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph qr [--ec level] [--qr-out file] [--paper file] <text> | qreph qr")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	text := strings.Join(fs.Args(), " ")
+	if fs.NArg() == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("failed to read from stdin: %v", err)
+		}
+		text = strings.TrimSuffix(string(data), "\n")
+	}
+	if text == "" {
+		log.Fatal("no content provided")
+	}
+	if err := qr.Fits(text, opts.Level); err != nil {
+		log.Fatalf("content does not fit in a QR code: %v", err)
+	}
+	// The code is what qreph qr is for, it always goes to stdout.
+	opts.swap = true
+	opts.draw(text, "")
+	opts.writePaper(paperPage("QR code backup", text))
+}
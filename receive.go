@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+const uploadPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>qreph</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+#drop { border: 2px dashed #888; border-radius: 8px; padding: 3em 1em; text-align: center; }
+#drop.over { background: #eef; }
+textarea { box-sizing: border-box; font-size: 1em; margin-bottom: 1em; width: 100%; }
+button { font-size: 1.2em; margin-top: 1em; width: 100%; }
+</style>
+</head>
+<body>
+<form id="form" method="post" enctype="multipart/form-data">
+<textarea name="text" rows="5" placeholder="Type or paste text"></textarea>
+<div id="drop">
+<p>Drop files here or</p>
+<input id="files" type="file" name="file" multiple>
+</div>
+<button type="submit">Send</button>
+</form>
+<script>
+var drop = document.getElementById("drop");
+drop.addEventListener("dragover", function (e) { e.preventDefault(); drop.className = "over"; });
+drop.addEventListener("dragleave", function () { drop.className = ""; });
+drop.addEventListener("drop", function (e) {
+	e.preventDefault();
+	document.getElementById("files").files = e.dataTransfer.files;
+	document.getElementById("form").submit();
+});
+</script>
+</body>
+</html>
+`
+
+type uploadStore struct {
+	mu       sync.Mutex
+	received bool
+}
+
+func (s *uploadStore) claim() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.received {
+		return false
+	}
+	s.received = true
+	return true
+}
+
+// release undoes a claim whose upload failed, so the sender can try again.
+func (s *uploadStore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = false
+}
+
+func receive(args []string) {
+	fs := flag.NewFlagSet("receive", flag.ExitOnError)
+	outDir := fs.String("o", ".", "write received files to `dir`, or - for stdout")
+	toClip := fs.Bool("to-clip", false, "put received text on the system clipboard instead of saving it")
+	opts := addServeFlags(fs)
+	parseFlags(fs, args)
+	opts.hardenProcess()
+
+	store := &uploadStore{}
+	done := make(chan struct{})
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, uploadPage)
+		case http.MethodPost:
+			if !store.claim() {
+				http.NotFound(w, r)
+				return
+			}
+			names, err := saveUploads(r, *outDir, *toClip)
+			for _, name := range names {
+				log.Printf("received %s", name)
+			}
+			if err != nil {
+				log.Printf("failed to receive upload, waiting for another: %v", err)
+				store.release()
+				http.Error(w, "upload failed, please try again", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, "Received %d item(s).\n", len(names))
+			close(done)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+
+	srv := newServer(opts)
+	os.Exit(srv.run("Upload files at:", "", func(string) http.Handler { return http.HandlerFunc(handler) }, done))
+}
+
+func saveUploads(r *http.Request, dir string, toClip bool) ([]string, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return names, err
+		}
+		if part.FileName() == "" {
+			if part.FormName() != "text" {
+				part.Close()
+				continue
+			}
+			name, err := saveText(part, dir, toClip)
+			part.Close()
+			if err != nil {
+				return names, err
+			}
+			if name != "" {
+				names = append(names, name)
+			}
+			continue
+		}
+		name, err := savePart(part, part.FileName(), dir)
+		part.Close()
+		if err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, errors.New("nothing in upload")
+	}
+	return names, nil
+}
+
+// saveText stores the text field of the upload page, if it was filled in.
+func saveText(r io.Reader, dir string, toClip bool) (string, error) {
+	text, err := io.ReadAll(io.LimitReader(r, 1<<20))
+	if err != nil || len(bytes.TrimSpace(text)) == 0 {
+		return "", err
+	}
+	if toClip {
+		return "text on the clipboard", writeClipboard(text)
+	}
+	return savePart(bytes.NewReader(text), "note.txt", dir)
+}
+
+func savePart(r io.Reader, filename, dir string) (string, error) {
+	if dir == "-" {
+		_, err := io.Copy(os.Stdout, r)
+		return filename, err
+	}
+	f, err := createUnique(dir, filepath.Base(filepath.FromSlash(filename)))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// savePayload writes a received note to dir, or to stdout when it has no
+// file name.
+func savePayload(p *share.Payload, dir string) {
+	if p.Name == "" || dir == "-" {
+		os.Stdout.Write(p.Data)
+		return
+	}
+	name, err := savePart(bytes.NewReader(p.Data), p.Name, dir)
+	if err != nil {
+		log.Fatalf("failed to save note: %v", err)
+	}
+	log.Printf("saved %s", name)
+}
+
+func createUnique(dir, name string) (*os.File, error) {
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		name = "upload"
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if !errors.Is(err, os.ErrExist) {
+			return f, err
+		}
+		candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
+}
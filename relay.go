@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/netutil"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// A relay stores end-to-end encrypted notes uploaded by senders that are not
+// reachable from the receiver's network and serves them under the same
+// one-time rules. It never sees the key, which stays in the URL fragment.
+
+const relayPollInterval = 25 * time.Second
+
+type relayNote struct {
+	token   string
+	path    string
+	store   *share.Store
+	handler http.Handler
+	done    chan struct{}
+	expired chan struct{}
+}
+
+type relayServer struct {
+	maxSize  int64
+	maxTTL   time.Duration
+	maxNotes int
+	maxTotal int64
+	upload   string // token senders must present, if set
+
+	mu     sync.Mutex
+	paths  map[string]*relayNote
+	tokens map[string]*relayNote
+	notes  int   // held in paths
+	bytes  int64 // of those notes
+}
+
+type relayCreated struct {
+	Path  string `json:"path"`
+	Token string `json:"token"`
+}
+
+func relay(args []string) {
+	fs := flag.NewFlagSet("relay", flag.ExitOnError)
+	addr := fs.String("listen", ":8080", "listen on `address`")
+	certFile := fs.String("cert", "", "serve HTTPS with the certificate in `file`")
+	keyFile := fs.String("key", "", "private key `file` for --cert")
+	maxSize := fs.Int64("max-size", 64<<20, "reject notes larger than `bytes`")
+	maxTTL := fs.Duration("max-ttl", 24*time.Hour, "drop notes after at most `duration`")
+	maxConns := fs.Int("max-conns", 1024, "keep at most `n` connections open at once; more wait (0 for no limit)")
+	maxNotes := fs.Int("max-notes", 1000, "hold at most `n` notes at once; uploads beyond get a 503")
+	maxTotal := fs.Int64("max-total", 1<<30, "hold at most `bytes` of notes at once; uploads beyond get a 503")
+	upload := fs.String("upload-token", os.Getenv("QREPH_RELAY_TOKEN"), "only take uploads that present `token`, as senders do from $QREPH_RELAY_TOKEN")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph relay [--listen address] [--cert file --key file] [--max-size bytes] [--max-ttl duration] [--max-notes n] [--max-total bytes] [--upload-token token]")
+		fmt.Fprintln(fs.Output(), "Uploads need no account unless --upload-token is set, so a public relay is bounded by --max-size, --max-notes and --max-total.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	rs := &relayServer{
+		maxSize:  *maxSize,
+		maxTTL:   *maxTTL,
+		maxNotes: *maxNotes,
+		maxTotal: *maxTotal,
+		upload:   *upload,
+		paths:    make(map[string]*relayNote),
+		tokens:   make(map[string]*relayNote),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /notes", rs.create)
+	mux.HandleFunc("GET /notes/{token}", rs.wait)
+	mux.HandleFunc("DELETE /notes/{token}", rs.revoke)
+	mux.HandleFunc("/n/{id}", rs.serveNote)
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("relay failed: %v", err)
+	}
+	if *maxConns > 0 {
+		l = netutil.LimitListener(l, *maxConns)
+	}
+	// No write or read timeout, as senders wait on GET /notes/{token} and
+	// notes may be big.
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second, IdleTimeout: time.Minute}
+	log.Printf("relay listening on %s", l.Addr())
+	if *certFile != "" {
+		err = srv.ServeTLS(l, *certFile, *keyFile)
+	} else {
+		err = srv.Serve(l)
+	}
+	log.Fatalf("relay failed: %v", err)
+}
+
+// full reports whether the relay holds as many notes or bytes as it may.
+func (rs *relayServer) full(size int64) bool {
+	return rs.notes >= rs.maxNotes || rs.bytes+size > rs.maxTotal
+}
+
+func (rs *relayServer) create(w http.ResponseWriter, r *http.Request) {
+	if rs.upload != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+rs.upload)) != 1 {
+		http.Error(w, "upload token required", http.StatusUnauthorized)
+		return
+	}
+	rs.mu.Lock()
+	full := rs.full(0)
+	rs.mu.Unlock()
+	if full {
+		// Before reading a body that would be thrown away.
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "relay is full, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, rs.maxSize))
+	if err != nil || len(content) == 0 {
+		http.Error(w, "bad or oversized note", http.StatusBadRequest)
+		return
+	}
+	count, _ := strconv.Atoi(r.Header.Get("X-Qreph-Count"))
+	if count < 1 {
+		count = 1
+	}
+	if r.Header.Get("X-Qreph-Keep") == "1" {
+		count = 0
+	}
+	ttl := rs.maxTTL
+	if secs, err := strconv.Atoi(r.Header.Get("X-Qreph-TTL")); err == nil && secs > 0 && time.Duration(secs)*time.Second < ttl {
+		ttl = time.Duration(secs) * time.Second
+	}
+
+	path, err := share.RandomPath()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	token, err := share.RandomPath()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	rn := &relayNote{
+		token: token[1:],
+		path:  "/n" + path,
+		store: share.NewStore(&share.Note{Content: content, ContentType: "application/octet-stream"},
+			share.WithMaxDownloads(count)),
+		done:    make(chan struct{}),
+		expired: make(chan struct{}),
+	}
+	rn.handler = share.BotFilter(nil, share.E2EHandler(share.NoteHandler(rn.store, rn.done)))
+
+	size := int64(len(content))
+	rs.mu.Lock()
+	if rs.full(size) {
+		rs.mu.Unlock()
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "relay is full, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	rs.paths[rn.path] = rn
+	rs.tokens[rn.token] = rn
+	rs.notes++
+	rs.bytes += size
+	rs.mu.Unlock()
+
+	go func() {
+		timer := time.NewTimer(ttl)
+		defer timer.Stop()
+		select {
+		case <-rn.done:
+		case <-rn.expired:
+		case <-timer.C:
+			if rn.store.Burn() {
+				close(rn.expired)
+			}
+		}
+		rs.mu.Lock()
+		delete(rs.paths, rn.path)
+		rs.notes--
+		rs.bytes -= size
+		rs.mu.Unlock()
+		// Keep the token around for a while so a sender between polls
+		// still learns the outcome.
+		time.AfterFunc(time.Minute, func() {
+			rs.mu.Lock()
+			delete(rs.tokens, rn.token)
+			rs.mu.Unlock()
+		})
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(relayCreated{Path: rn.path, Token: rn.token})
+}
+
+func (rs *relayServer) lookup(m map[string]*relayNote, key string) *relayNote {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return m[key]
+}
+
+func (rs *relayServer) serveNote(w http.ResponseWriter, r *http.Request) {
+	rn := rs.lookup(rs.paths, r.URL.Path)
+	if rn == nil {
+		http.NotFound(w, r)
+		return
+	}
+	rn.handler.ServeHTTP(w, r)
+}
+
+// wait blocks until the note is fetched (200), expires (410) or the poll
+// interval passes (204), so senders can keep polling through proxies that
+// cut idle requests.
+func (rs *relayServer) wait(w http.ResponseWriter, r *http.Request) {
+	rn := rs.lookup(rs.tokens, r.PathValue("token"))
+	if rn == nil {
+		http.Error(w, "gone", http.StatusGone)
+		return
+	}
+	select {
+	case <-rn.done:
+		w.WriteHeader(http.StatusOK)
+	case <-rn.expired:
+		http.Error(w, "expired", http.StatusGone)
+	case <-time.After(relayPollInterval):
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+}
+
+func (rs *relayServer) revoke(w http.ResponseWriter, r *http.Request) {
+	rn := rs.lookup(rs.tokens, r.PathValue("token"))
+	if rn == nil {
+		http.Error(w, "gone", http.StatusGone)
+		return
+	}
+	if rn.store.Burn() {
+		close(rn.expired)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// relaySend uploads n, which must already be end-to-end encrypted, to the
+// relay at base and waits until it has been fetched. The relay does not
+// say who fetched it, so hooks only learn when.
+func relaySend(base string, n *share.Note, key string, count int, keep bool, opts *serveOptions, hooks *deliveryHooks) {
+	base = strings.TrimSuffix(base, "/")
+	req, err := http.NewRequest(http.MethodPost, base+"/notes", bytes.NewReader(n.Content))
+	if err != nil {
+		log.Fatalf("invalid relay url: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if token := os.Getenv("QREPH_RELAY_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("X-Qreph-Count", strconv.Itoa(count))
+	if keep {
+		req.Header.Set("X-Qreph-Keep", "1")
+	}
+	if opts.ttl > 0 {
+		req.Header.Set("X-Qreph-TTL", strconv.Itoa(int(opts.ttl.Seconds())))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("failed to upload to relay: %v", err)
+	}
+	var created relayCreated
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || err != nil {
+		log.Fatalf("failed to upload to relay: %s", resp.Status)
+	}
+
+	url := base + created.Path + "#" + key
+	opts.qr.show("Serving note at:", url, "")
+	if opts.json {
+		opts.printJSON([]string{""}, []string{url}, created.Path)
+	}
+
+	fetched := make(chan bool)
+	go func() {
+		for {
+			resp, err := http.Get(base + "/notes/" + created.Token)
+			if err != nil {
+				log.Printf("lost relay, retrying: %v", err)
+				time.Sleep(5 * time.Second)
+				continue
+			}
+			resp.Body.Close()
+			switch resp.StatusCode {
+			case http.StatusOK:
+				fetched <- true
+				return
+			case http.StatusNoContent:
+			case http.StatusGone:
+				fetched <- false
+				return
+			default:
+				log.Fatalf("relay error: %s", resp.Status)
+			}
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	notifyStop(stop)
+
+	select {
+	case ok := <-fetched:
+		if !ok {
+			log.Print("note expired on the relay")
+			os.Exit(exitNotFetched)
+		}
+		hooks.delivered(share.Delivery{Time: time.Now(), Bytes: int64(len(n.Content))})
+		hooks.wait()
+	case <-stop:
+		req, _ := http.NewRequest(http.MethodDelete, base+"/notes/"+created.Token, nil)
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+		os.Exit(exitInterrupted)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/kevinkokinda/qreph/pkg/qr"
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// Frames are grabbed with ffmpeg, which knows how to talk to the camera on
+// every platform, as small grayscale images.
+const (
+	scanWidth  = 640
+	scanHeight = 480
+)
+
+func defaultCamera() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "0"
+	case "windows":
+		return "" // named after the hardware, see ffmpeg -list_devices true -f dshow -i dummy
+	}
+	return "/dev/video0"
+}
+
+func cameraInput(device string) []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"-f", "avfoundation", "-framerate", "30", "-i", device}
+	case "windows":
+		return []string{"-f", "dshow", "-i", device}
+	}
+	return []string{"-f", "v4l2", "-i", device}
+}
+
+func scan(args []string) {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	device := fs.String("device", defaultCamera(), "capture from camera `device`")
+	fetchNotes := fs.Bool("fetch", false, "download the note when the QR code holds a URL instead of printing it")
+	outDir := fs.String("o", ".", "write files to `dir`, or - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph scan [--device name] [--fetch] [-o dir]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *device == "" {
+		log.Fatal("--device is required, e.g. --device \"video=Integrated Camera\"")
+	}
+
+	ffmpegArgs := append([]string{"-loglevel", "error", "-nostdin"}, cameraInput(*device)...)
+	ffmpegArgs = append(ffmpegArgs,
+		"-vf", fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%[1]d:%[2]d", scanWidth, scanHeight),
+		"-r", "10", "-pix_fmt", "gray", "-f", "rawvideo", "-")
+	cmd := exec.Command("ffmpeg", ffmpegArgs...)
+	cmd.Stderr = os.Stderr
+	frames, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Fatalf("failed to start camera: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Fatalf("failed to start camera (is ffmpeg installed?): %v", err)
+	}
+	defer cmd.Process.Kill()
+
+	fmt.Fprintln(os.Stderr, "Hold the QR code up to the camera (Ctrl-C to stop)")
+	img := image.NewGray(image.Rect(0, 0, scanWidth, scanHeight))
+	var d frameDecoder
+	for {
+		if _, err := io.ReadFull(frames, img.Pix); err != nil {
+			log.Fatalf("camera stopped: %v", err)
+		}
+		text, err := qr.Decode(img)
+		if err != nil {
+			continue
+		}
+
+		if !strings.HasPrefix(text, framePrefix) {
+			if *fetchNotes && (strings.HasPrefix(text, "http://") || strings.HasPrefix(text, "https://")) {
+				if err := fetchNote(text, *outDir); err != nil {
+					log.Fatalf("failed to fetch note: %v", err)
+				}
+				return
+			}
+			fmt.Println(text)
+			return
+		}
+
+		h, data, err := parseFrame(text)
+		if err != nil {
+			continue
+		}
+		complete, err := d.add(h, data)
+		if err != nil {
+			continue
+		}
+		if !complete {
+			fmt.Fprintf(os.Stderr, "\r%d/%d blocks", len(d.rows), h.blocks)
+			continue
+		}
+		fmt.Fprintln(os.Stderr)
+		p, err := d.sealedPayload()
+		if err != nil {
+			log.Fatalf("failed to assemble note: %v", err)
+		}
+		savePayload(p, *outDir)
+		return
+	}
+}
+
+// fetchNote downloads a note served by qreph and saves it to outDir.
+func fetchNote(raw, outDir string) error {
+	p, _, err := share.Fetch(raw)
+	if err != nil {
+		return err
+	}
+	savePayload(p, outDir)
+	return nil
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"golang.org/x/term"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// send serves a note once and shows its URL as a QR code.
+func send(args []string) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	var filePaths, texts []string
+	fs.Func("f", "serve the file at `path` instead of text; repeat it, or list more files after it, for a URL per file", func(s string) error {
+		filePaths = append(filePaths, s)
+		return nil
+	})
+	fs.Func("note", "serve `text` as a note with a URL of its own (repeatable)", func(s string) error {
+		texts = append(texts, s)
+		return nil
+	})
+	dirPath := fs.String("d", "", "serve the directory at `path` as a zip archive")
+	fromClip := fs.Bool("clip", false, "serve the contents of the system clipboard")
+	passEntry := fs.String("pass", "", "serve the password in `entry` of the password store, read with pass or gopass")
+	keychain := fs.String("keychain", "", "serve the secret of the item called `name` in the macOS Keychain, Windows Credential Manager or Secret Service")
+	vaultRef := fs.String("vault", "", "serve a field of a HashiCorp Vault secret, named by its API path and field as `path#field` (e.g. secret/data/foo#password)")
+	prompt := fs.Bool("p", false, "prompt for the note on the terminal without echoing it, so it stays out of ps and shell history")
+	var download bool
+	var downloadName string
+	fs.BoolFunc("download", "make the phone save the note as a file instead of showing it; --download=`name` also sets the file name", func(s string) error {
+		switch s {
+		case "true":
+			download = true
+		case "false":
+			download = false
+		default:
+			download, downloadName = true, filepath.Base(s)
+		}
+		return nil
+	})
+	lang := fs.String("lang", "", "show the note to browsers as code in `language` (e.g. go, or auto to guess; default from the file extension)")
+	copyPage := fs.Bool("copy-page", false, "show the note to browsers on a page with a big Copy button")
+	markdown := fs.Bool("markdown", false, "show the note to browsers as rendered Markdown (default for .md files)")
+	noCompress := fs.Bool("no-compress", false, "never compress text notes, even for clients that accept gzip or zstd")
+	contentType := fs.String("content-type", "", "serve the note with MIME `type` instead of the detected one")
+	count := fs.Int("count", 1, "allow the note to be fetched `n` times before it burns")
+	keep := fs.Bool("keep", false, "serve the note until interrupted or --ttl expires instead of once")
+	linkTTL := fs.Duration("link-ttl", 0, "with --keep, make each URL stop working `duration` after it is shown; r shows a new one")
+	usePIN := fs.Bool("pin", false, "require a numeric PIN, printed here, before releasing the note")
+	useCode := fs.Bool("code", false, "protect the note with a code phrase for use with qreph fetch")
+	var ageRecipients []age.Recipient
+	fs.Func("age", "encrypt the note to the age `recipient` before serving (repeatable)", func(s string) error {
+		r, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return err
+		}
+		ageRecipients = append(ageRecipients, r)
+		return nil
+	})
+	relayURL := fs.String("relay", "", "upload the note end-to-end encrypted to the relay at `url` instead of serving it locally")
+	direct := fs.Bool("direct", false, "put the text itself in the QR code and serve nothing, for content small enough to fit")
+	animated := fs.Bool("animate", false, "show the note as a loop of QR frames for qreph assemble and serve nothing, for transfers without any network")
+	fps := fs.Int("fps", 5, "show `n` frames per second with --animate")
+	frameSize := fs.Int("frame-size", 128, "put `bytes` of the note in each --animate frame")
+	signKey := fs.String("sign", "", "sign the note with the Ed25519 private key in `file`, for qreph verify")
+	useMTLS := fs.Bool("mtls", false, "release the note only to the device that fetched the one client certificate issued over its URL (implies --tls)")
+	useE2E := fs.Bool("e2e", false, "encrypt the note with a key kept in the URL fragment and decrypt it in the browser (implies --tls)")
+	var bots []string
+	fs.Func("bot", "also turn away clients whose User-Agent contains `text` (repeatable)", func(s string) error {
+		bots = append(bots, s)
+		return nil
+	})
+	useToken := fs.Bool("token", false, "also require the token printed here in an Authorization: Bearer header, for curl")
+	tokenOnly := fs.Bool("token-only", false, "like --token, but serve the note at a fixed path so the URL holds no secret")
+	mlock := fs.Bool("mlock", false, "lock the note in memory so it is never written to swap")
+	allowBots := fs.Bool("allow-bots", false, "serve crawlers and link preview bots like any other client")
+	opts := addServeFlags(fs)
+	hooks := addHookFlags(fs)
+	var notBefore time.Time
+	fs.Func("not-before", "keep the note unavailable, without using it up, until `time`: a time of day like 18:00 or a date and time like \"2026-10-20 18:00\"", func(s string) (err error) {
+		notBefore, err = parseNotBefore(s)
+		return err
+	})
+	timeout := fs.Duration("timeout", 0, "give up once nobody has fetched the note for `duration`, counting down on the terminal")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph send [--tls] [--ttl duration] [--count n | --keep] [--pin | --code | --e2e | --mtls | --relay url | --direct | --animate] [--age recipient] [-f path... | --note text... | -d path | --clip | -p | --pass entry | --keychain name | --vault path#field] [text]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	opts.hardenProcess()
+
+	if *count < 1 {
+		log.Fatal("--count must be at least 1")
+	}
+	if len(filePaths) > 0 {
+		filePaths = append(filePaths, fs.Args()...)
+	}
+	many := len(filePaths)+len(texts) > 1
+	if many && (*dirPath != "" || *fromClip || *usePIN || *useCode || *useE2E || *relayURL != "" || *direct || *animated || *signKey != "" || opts.tui || *timeout > 0) {
+		log.Fatal("several notes cannot be combined with -d, --clip, --pin, --code, --e2e, --relay, --direct, --animate, --sign, --tui or --timeout")
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "count" && *keep {
+			log.Fatal("--count and --keep are mutually exclusive")
+		}
+	})
+	if *usePIN && *useCode {
+		log.Fatal("--pin and --code are mutually exclusive")
+	}
+	if *useCode && *useE2E {
+		log.Fatal("--code and --e2e are mutually exclusive")
+	}
+	if *direct && (*usePIN || *useCode || *useE2E || *relayURL != "" || len(ageRecipients) > 0 || *dirPath != "") {
+		log.Fatal("--direct cannot be combined with -d, --pin, --code, --e2e, --relay or --age")
+	}
+	if *animated {
+		if *direct || *usePIN || *useCode || *useE2E || *relayURL != "" {
+			log.Fatal("--animate cannot be combined with --direct, --pin, --code, --e2e or --relay")
+		}
+		if *fps < 1 || *frameSize < 1 {
+			log.Fatal("--fps and --frame-size must be at least 1")
+		}
+	}
+	if opts.tui {
+		if *direct || *animated || *relayURL != "" || *prompt {
+			log.Fatal("--tui cannot be combined with --direct, --animate, --relay or -p")
+		}
+		// Before anything is printed, so it all ends up on the screen.
+		opts.startTUI()
+	}
+	if *relayURL != "" {
+		if *usePIN || *useCode {
+			log.Fatal("--relay cannot be combined with --pin or --code")
+		}
+		*useE2E = true
+	}
+	if *tokenOnly {
+		if many {
+			log.Fatal("--token-only cannot be combined with several notes")
+		}
+		*useToken = true
+	}
+	if *linkTTL > 0 {
+		if !*keep || *useCode || *relayURL != "" || *direct || *animated {
+			log.Fatal("--link-ttl needs --keep and cannot be combined with --code, --relay, --direct or --animate")
+		}
+		opts.linkTTL = *linkTTL
+	}
+	if !notBefore.IsZero() {
+		switch {
+		case *relayURL != "" || *direct || *animated:
+			log.Fatal("--not-before cannot be combined with --relay, --direct or --animate")
+		case opts.ttl > 0 && time.Now().Add(opts.ttl).Before(notBefore), *timeout > 0 && time.Now().Add(*timeout).Before(notBefore):
+			log.Fatal("--ttl or --timeout would run out before --not-before")
+		}
+	}
+	if *useToken && (*relayURL != "" || *direct || *animated) {
+		log.Fatal("--token cannot be combined with --relay, --direct or --animate")
+	}
+	if *useMTLS {
+		if many || *relayURL != "" || *direct || *animated || opts.publicURL != "" || opts.listen != "" {
+			log.Fatal("--mtls cannot be combined with several notes, --relay, --direct, --animate, --public-url or --listen")
+		}
+		// The client certificate has to reach qreph itself.
+		opts.tls = true
+	}
+	if *mlock && *dirPath != "" {
+		log.Fatal("--mlock cannot be combined with -d, which is read as it is served")
+	}
+	if *useE2E && !strings.HasPrefix(opts.publicURL, "https://") {
+		// Browsers only expose WebCrypto to secure contexts.
+		opts.tls = true
+	}
+
+	var n *share.Note
+	var notes []*share.Note // with several, each is served at a URL of its own
+	var names []string
+	switch {
+	case (len(filePaths) > 0 || len(texts) > 0) && *dirPath != "":
+		log.Fatal("-f and --note cannot be combined with -d")
+	case *fromClip && (len(filePaths) > 0 || len(texts) > 0 || *dirPath != ""):
+		log.Fatal("--clip cannot be combined with -f, --note or -d")
+	case *vaultRef != "" && (len(filePaths) > 0 || len(texts) > 0 || *dirPath != "" || *fromClip || *prompt || *passEntry != "" || *keychain != "" || fs.NArg() > 0):
+		log.Fatal("--vault cannot be combined with -f, --note, -d, --clip, -p, --pass, --keychain or text arguments")
+	case *keychain != "" && (len(filePaths) > 0 || len(texts) > 0 || *dirPath != "" || *fromClip || *prompt || *passEntry != "" || fs.NArg() > 0):
+		log.Fatal("--keychain cannot be combined with -f, --note, -d, --clip, -p, --pass or text arguments")
+	case *passEntry != "" && (len(filePaths) > 0 || len(texts) > 0 || *dirPath != "" || *fromClip || *prompt || fs.NArg() > 0):
+		log.Fatal("--pass cannot be combined with -f, --note, -d, --clip, -p or text arguments")
+	case *prompt && (len(filePaths) > 0 || len(texts) > 0 || *dirPath != "" || *fromClip || fs.NArg() > 0):
+		log.Fatal("-p cannot be combined with -f, --note, -d, --clip or text arguments")
+	case many:
+		for _, path := range filePaths {
+			n, err := share.ReadFile(path)
+			if err != nil {
+				log.Fatalf("failed to read file: %v", err)
+			}
+			notes, names = append(notes, n), append(names, n.Filename)
+		}
+		for i, text := range texts {
+			content := []byte(text)
+			notes = append(notes, &share.Note{Content: content, ContentType: share.DetectContentType(content)})
+			names = append(names, fmt.Sprintf("note %d", i+1))
+		}
+	case *fromClip:
+		content, err := readClipboard()
+		if err != nil {
+			log.Fatalf("failed to read clipboard: %v", err)
+		}
+		n = &share.Note{Content: content, ContentType: share.DetectContentType(content)}
+	case *vaultRef != "":
+		content, err := readVault(*vaultRef)
+		if err != nil {
+			log.Fatalf("failed to read from Vault: %v", err)
+		}
+		n = &share.Note{Content: content, ContentType: share.DetectContentType(content)}
+	case *keychain != "":
+		content, err := readKeychain(*keychain)
+		if err != nil {
+			log.Fatalf("failed to read from the keychain: %v", err)
+		}
+		n = &share.Note{Content: content, ContentType: share.DetectContentType(content)}
+	case *passEntry != "":
+		content, err := readPass(*passEntry)
+		if err != nil {
+			log.Fatalf("failed to read from the password store: %v", err)
+		}
+		n = &share.Note{Content: content, ContentType: share.DetectContentType(content)}
+	case *prompt:
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			log.Fatal("-p needs a terminal; pipe the note into stdin instead")
+		}
+		content, err := readPassword("Secret")
+		if err != nil {
+			log.Fatalf("failed to read the secret: %v", err)
+		}
+		n = &share.Note{Content: content, ContentType: share.DetectContentType(content)}
+	case len(filePaths) == 1:
+		var err error
+		n, err = share.ReadFile(filePaths[0])
+		if err != nil {
+			log.Fatalf("failed to read file: %v", err)
+		}
+	case len(texts) == 1:
+		content := []byte(texts[0])
+		n = &share.Note{Content: content, ContentType: share.DetectContentType(content)}
+	case *dirPath != "":
+		var err error
+		n, err = share.Dir(*dirPath)
+		if err != nil {
+			log.Fatalf("failed to open directory: %v", err)
+		}
+	default:
+		var content []byte
+		if stdinPiped() {
+			var err error
+			content, err = io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatalf("failed to read from stdin: %v", err)
+			}
+		} else {
+			if fs.NArg() < 1 {
+				fs.Usage()
+				return
+			}
+			content = []byte(strings.Join(fs.Args(), " "))
+		}
+		n = &share.Note{Content: content, ContentType: share.DetectContentType(content)}
+	}
+
+	if n != nil {
+		notes = append(notes, n)
+	}
+	for _, n := range notes {
+		if len(n.Content) == 0 && n.Stream == nil {
+			log.Fatal("no content provided")
+		}
+
+		if *contentType != "" {
+			if _, _, err := mime.ParseMediaType(*contentType); err != nil {
+				log.Fatalf("invalid --content-type: %v", err)
+			}
+			n.ContentType = *contentType
+		}
+
+		n.View, n.Lang = share.DetectView(n)
+		switch {
+		case *markdown && *lang != "", *copyPage && (*markdown || *lang != ""):
+			log.Fatal("--markdown, --lang and --copy-page are mutually exclusive")
+		case *copyPage:
+			n.View = share.ViewCopy
+		case *markdown:
+			n.View = share.ViewMarkdown
+		case *lang != "":
+			if _, err := share.CodeLexer(*lang, nil); err != nil {
+				log.Fatal(err)
+			}
+			n.View, n.Lang = share.ViewCode, *lang
+		}
+
+		if download {
+			n.View = ""
+			switch {
+			case downloadName != "":
+				n.Filename = downloadName
+			case n.Filename == "":
+				n.Filename = "note.txt"
+			}
+		}
+	}
+
+	if *mlock {
+		for _, n := range notes {
+			if err := lockMemory(n.Content); err != nil {
+				log.Fatalf("failed to lock the note in memory: %v (raise ulimit -l)", err)
+			}
+		}
+	}
+
+	downloads := *count
+	if *keep {
+		downloads = 0
+		// Long enough up for a page using DNS rebinding to find it.
+		opts.hostCheck = true
+	}
+	storeOpts := append(hooks.options(), share.WithMaxDownloads(downloads))
+	if !*noCompress {
+		storeOpts = append(storeOpts, share.WithCompression())
+	}
+	var token string
+	if *useToken {
+		token = rand.Text()
+		opts.detail("token", "Token:", token)
+	}
+	if !notBefore.IsZero() {
+		opts.detail("not_before", "Available from:", notBefore.Format("Jan 2 15:04"))
+	}
+	filter := func(handler http.Handler) http.Handler {
+		if token != "" {
+			handler = share.TokenHandler(token, handler)
+		}
+		if !notBefore.IsZero() {
+			handler = share.NotBeforeHandler(notBefore, handler)
+		}
+		if !*allowBots {
+			handler = share.BotFilter(bots, handler)
+		}
+		return handler
+	}
+
+	if many {
+		sendMany(notes, names, ageRecipients, storeOpts, filter, opts, hooks)
+		return
+	}
+
+	if *direct {
+		opts.qr.showDirect("Scan to read the note:", string(n.Content))
+		return
+	}
+
+	if *signKey != "" {
+		key, err := loadSigningKey(*signKey)
+		if err != nil {
+			log.Fatalf("failed to read signing key: %v", err)
+		}
+		// The signature has to be known before anything is sent.
+		if n.Content, err = n.Bytes(); err != nil {
+			log.Fatalf("failed to read note: %v", err)
+		}
+		n.Stream = nil
+		n.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(key, n.Content))
+		opts.detail("signed_by", "Signed by:", keyFingerprint(key.Public().(ed25519.PublicKey)))
+		opts.detail("signature", "Signature:", n.Sig)
+	}
+
+	if n.Stream == nil {
+		sum := sha256.Sum256(n.Content)
+		n.Sum = hex.EncodeToString(sum[:])
+		opts.detail("checksum", "Content SHA-256:", n.Sum)
+	}
+
+	if len(ageRecipients) > 0 {
+		n = share.Age(n, ageRecipients)
+	}
+
+	if *animated {
+		animate(n, opts.qr, *frameSize, *fps)
+		return
+	}
+
+	var key string
+	if *useE2E {
+		var err error
+		n, key, err = share.SealE2E(n)
+		if err != nil {
+			log.Fatalf("failed to encrypt note: %v", err)
+		}
+	}
+
+	if *relayURL != "" {
+		relaySend(*relayURL, n, key, *count, *keep, opts, hooks)
+		return
+	}
+
+	store := share.NewStore(n, storeOpts...)
+
+	done := make(chan struct{})
+
+	if *useMTLS {
+		var err error
+		if opts.clientCA, err = share.NewClientCA(); err != nil {
+			log.Fatalf("failed to create client certificate authority: %v", err)
+		}
+		opts.detail("cert_password", "Certificate password:", opts.clientCA.Password())
+	}
+	srv := newServer(opts)
+	srv.store, srv.size, srv.idleTimeout = store, int64(len(n.Content)), *timeout
+	if *tokenOnly {
+		srv.fixedPath = "/note"
+	}
+	var phrase string
+	if *useCode {
+		var err error
+		phrase, err = share.NewCodePhrase()
+		if err != nil {
+			log.Fatalf("failed to generate code phrase: %v", err)
+		}
+		opts.detail("code", "Code phrase:", phrase)
+	}
+	var gate *share.PINGate
+	if *usePIN {
+		var err error
+		gate, err = share.NewPINGate()
+		if err != nil {
+			log.Fatalf("failed to generate PIN: %v", err)
+		}
+		opts.detail("pin", "PIN:", gate.PIN())
+	}
+	var fragment string
+	if *useE2E {
+		fragment = key
+	} else if srv.Cert() != nil && !*useCode {
+		fragment = share.SPKIPin(srv.Cert())
+	}
+	// The code phrase is bound to the path, so a new path needs new
+	// handlers.
+	handler := func(path string) http.Handler {
+		var handler http.Handler = share.NoteHandler(store, done)
+		if *useCode {
+			handler = share.PAKEHandler(store, phrase, path, done)
+		} else if *useE2E {
+			handler = share.E2EHandler(handler)
+		} else if srv.Cert() != nil {
+			handler = share.PinnedHandler(store, srv.Cert(), handler, done)
+		} else if !*usePIN {
+			handler = share.ConfirmHandler(handler)
+		}
+		if gate != nil {
+			handler = gate.Handler(store, handler, done)
+		}
+		if opts.clientCA != nil {
+			handler = opts.clientCA.Handler(handler)
+		}
+		return filter(handler)
+	}
+	code := srv.run("Serving note at:", fragment, handler, done)
+	store.Burn()
+	hooks.wait()
+	switch {
+	case store.Fetches() > 0:
+		code = exitFetched
+	case code == exitFetched:
+		// done was closed by the PIN gate destroying the note.
+		code = exitNotFetched
+	}
+	os.Exit(code)
+}
+
+// parseNotBefore reads --not-before. A time of day is the next time it
+// comes round.
+func parseNotBefore(s string) (time.Time, error) {
+	now := time.Now()
+	if clock, err := time.ParseInLocation("15:04", s, time.Local); err == nil {
+		t := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local)
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, _, err := parseEventTime(s)
+	if err != nil {
+		return t, err
+	}
+	if !t.After(now) {
+		return t, errors.New("the time has passed")
+	}
+	return t, nil
+}
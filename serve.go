@@ -0,0 +1,603 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// serveOptions are the flags of every command that runs a server.
+type serveOptions struct {
+	tls       bool
+	tlsKey    *ecdsa.PrivateKey // kept by the daemon's --store
+	http3     bool
+	clientCA  *share.ClientCA // with send --mtls
+	harden    bool
+	linkTTL   time.Duration // with send --keep and the daemon
+	linkKey   []byte        // kept by the daemon's --store
+	ttl       time.Duration
+	mdns      bool
+	iface     string
+	ip        string
+	port      int
+	listen    string       // --listen unix:path
+	listener  net.Listener // from systemd, for the daemon
+	publicURL string
+	allIfaces bool
+	wan       bool
+	tailscale bool
+	rate      int
+	allow     []*net.IPNet
+	lanOnly   bool
+	hostCheck bool // for servers that stay up, set by the command
+	perMinute int
+	banAfter  int
+	maxConns  int
+	qr        *qrOptions
+
+	json      bool
+	details   map[string]any // collected for --json
+	logFile   string
+	verbosity int          // -1 for --quiet, 1 for -v, 2 for -vv
+	log       *slog.Logger // for debug messages of the CLI
+	tui       bool
+	screen    *tui // with --tui
+}
+
+func addServeFlags(fs *flag.FlagSet) *serveOptions {
+	opts := &serveOptions{qr: addQRFlags(fs)}
+	fs.BoolVar(&opts.tls, "tls", false, "serve over HTTPS with an ephemeral self-signed certificate")
+	fs.BoolVar(&opts.http3, "http3", false, "also serve HTTP/3 over QUIC on the same port, advertised with Alt-Svc (needs --tls)")
+	fs.DurationVar(&opts.ttl, "ttl", 0, "shut down if nobody fetches within `duration` (e.g. 5m)")
+	fs.BoolVar(&opts.mdns, "mdns", false, "advertise qreph.local over mDNS and use it in the URL")
+	fs.StringVar(&opts.iface, "iface", "", "use the address of network interface `name` in the URL")
+	fs.StringVar(&opts.ip, "ip", "", "use `address` in the URL instead of guessing it")
+	fs.IntVar(&opts.port, "port", 0, "listen on `port` instead of a random one")
+	fs.BoolVar(&opts.allIfaces, "all-ifaces", false, "print a URL and QR code for every usable network interface")
+	fs.BoolVar(&opts.wan, "wan", false, "forward a port on the router via NAT-PMP or UPnP and put the public address in the URL")
+	fs.BoolVar(&opts.tailscale, "tailscale", false, "serve from a tailnet node of qreph's own, with its MagicDNS name in the URL")
+	fs.StringVar(&opts.listen, "listen", "", "listen on the unix socket `unix:path` instead of a TCP port, for a reverse proxy serving --public-url")
+	fs.StringVar(&opts.publicURL, "public-url", "", "put `url` in the QR code instead of the local address, for use behind a proxy or port forward")
+	fs.BoolFunc("json", "print the URL and details as one JSON object on stdout and draw the QR code on stderr", func(s string) (err error) {
+		opts.json, err = strconv.ParseBool(s)
+		opts.qr.json = opts.json
+		return err
+	})
+	fs.BoolFunc("v", "also log connections, TLS handshakes and why the server stopped", func(s string) error {
+		on, err := strconv.ParseBool(s)
+		if on {
+			opts.verbosity = max(opts.verbosity, 1)
+		}
+		return err
+	})
+	fs.BoolFunc("vv", "like -v, and also log the headers of every request", func(s string) error {
+		on, err := strconv.ParseBool(s)
+		if on {
+			opts.verbosity = 2
+		}
+		return err
+	})
+	fs.BoolFunc("quiet", "print only the URL, and the PIN or code phrase if there is one", func(s string) error {
+		on, err := strconv.ParseBool(s)
+		if on {
+			opts.verbosity, opts.qr.noQR = -1, true
+		}
+		return err
+	})
+	fs.BoolVar(&opts.tui, "tui", false, "take over the terminal with the QR code, URL, transfer progress and log until done")
+	fs.BoolVar(&opts.harden, "harden", false, "disable core dumps and, on Linux, ptrace and /proc access to this process while it holds the note")
+	fs.StringVar(&opts.logFile, "log-file", "", "append the access log and other messages to `path` instead of only printing them")
+	fs.Func("allow", "only serve peers in the network `cidr` (e.g. 192.168.1.0/24, repeatable); others get a 404", func(s string) error {
+		n, err := share.ParseNet(s)
+		if err != nil {
+			return err
+		}
+		opts.allow = append(opts.allow, n)
+		return nil
+	})
+	fs.BoolVar(&opts.lanOnly, "lan-only", false, "only serve peers on the subnet of the address in the URL; others get a 404")
+	fs.IntVar(&opts.perMinute, "max-requests", 120, "answer a peer asking more than `n` times a minute with 429 (0 for no limit)")
+	fs.IntVar(&opts.banAfter, "ban-after", 10, "shut a peer out for 10 minutes after `n` requests in a row for wrong paths (0 to never)")
+	fs.IntVar(&opts.maxConns, "max-conns", 64, "keep at most `n` connections open at once; more wait (0 for no limit)")
+	fs.Func("limit-rate", "cap the transfer speed of all downloads at `rate` (e.g. 1MB/s)", func(s string) (err error) {
+		opts.rate, err = share.ParseRate(s)
+		return err
+	})
+	return opts
+}
+
+// hardenProcess applies --harden. Call it before reading the note.
+func (o *serveOptions) hardenProcess() {
+	if !o.harden {
+		return
+	}
+	if err := harden(); err != nil {
+		log.Fatalf("failed to harden the process: %v", err)
+	}
+}
+
+// options translates the flags for share.New.
+func (o *serveOptions) options() []share.Option {
+	var opts []share.Option
+	if o.tls {
+		opts = append(opts, share.WithTLS())
+	}
+	if o.tlsKey != nil {
+		opts = append(opts, share.WithTLSKey(o.tlsKey))
+	}
+	if o.http3 {
+		opts = append(opts, share.WithHTTP3())
+	}
+	if o.linkTTL > 0 {
+		opts = append(opts, share.WithLinkExpiry(o.linkTTL))
+	}
+	if o.linkKey != nil {
+		opts = append(opts, share.WithLinkKey(o.linkKey))
+	}
+	if o.clientCA != nil {
+		opts = append(opts, share.WithClientCA(o.clientCA))
+	}
+	if o.ttl > 0 {
+		opts = append(opts, share.WithTTL(o.ttl))
+	}
+	if o.mdns {
+		opts = append(opts, share.WithMDNS())
+	}
+	if o.iface != "" {
+		opts = append(opts, share.WithInterface(o.iface))
+	}
+	if o.ip != "" {
+		opts = append(opts, share.WithIP(o.ip))
+	}
+	if o.port != 0 {
+		opts = append(opts, share.WithPort(o.port))
+	}
+	if o.listener != nil {
+		opts = append(opts, share.WithListener(o.listener))
+	}
+	if o.publicURL != "" {
+		opts = append(opts, share.WithPublicURL(o.publicURL))
+	}
+	if o.allIfaces {
+		opts = append(opts, share.WithAllInterfaces())
+	}
+	if o.wan {
+		opts = append(opts, share.WithWAN())
+	}
+	if o.tailscale {
+		opts = append(opts, share.WithTailscale())
+	}
+	if o.rate > 0 {
+		opts = append(opts, share.WithRateLimit(o.rate))
+	}
+	if len(o.allow) > 0 {
+		opts = append(opts, share.WithAllowedNets(o.allow...))
+	}
+	if o.lanOnly {
+		opts = append(opts, share.WithLANOnly())
+	}
+	if o.hostCheck {
+		opts = append(opts, share.WithHostCheck())
+	}
+	if o.perMinute > 0 {
+		opts = append(opts, share.WithRequestLimit(o.perMinute))
+	}
+	if o.banAfter > 0 {
+		opts = append(opts, share.WithBanAfter(o.banAfter))
+	}
+	if o.maxConns > 0 {
+		opts = append(opts, share.WithMaxConns(o.maxConns))
+	}
+	return append(opts, o.loggers()...)
+}
+
+// loggers sets up the access log and the other messages as --quiet, -v,
+// -vv and --log-file say. With --log-file the access log goes only to the
+// file, which also gets a copy of every other message.
+func (o *serveOptions) loggers() []share.Option {
+	level := slog.LevelInfo
+	switch o.verbosity {
+	case 1:
+		level = slog.LevelDebug
+	case 2:
+		level = share.LevelTrace
+	}
+	var handler slog.Handler
+	switch {
+	case o.logFile != "":
+		f, err := os.OpenFile(o.logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			log.Fatalf("failed to open log file: %v", err)
+		}
+		handler = o.logHandler(f, level)
+	case o.verbosity >= 0:
+		handler = o.logHandler(o.stderr(), level)
+	default:
+		o.log = slog.New(slog.DiscardHandler)
+		return []share.Option{share.WithLogger(log.New(io.Discard, "", 0))}
+	}
+	o.log = slog.New(handler)
+	file := slog.NewLogLogger(handler, slog.LevelInfo)
+	opts := []share.Option{share.WithAccessLog(o.log)}
+	switch {
+	case o.logFile != "" && o.verbosity < 0:
+		opts = append(opts, share.WithLogger(file))
+	case o.logFile != "":
+		log.SetOutput(io.MultiWriter(o.stderr(), file.Writer()))
+	case o.screen != nil:
+		log.SetOutput(o.screen)
+	}
+	return opts
+}
+
+// stderr is where messages go, the log pane with --tui.
+func (o *serveOptions) stderr() io.Writer {
+	if o.screen != nil {
+		return o.screen
+	}
+	return os.Stderr
+}
+
+// say prints a message for the user.
+func (o *serveOptions) say(msg string) {
+	if o.screen != nil {
+		o.screen.say(msg)
+		return
+	}
+	fmt.Fprintln(o.qr.human(), msg)
+}
+
+func (o *serveOptions) logHandler(w io.Writer, level slog.Level) slog.Handler {
+	if o.json {
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	}
+	return slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})
+}
+
+type server struct {
+	*share.Server
+	opts *serveOptions
+
+	// Set by send: the note, its size if known, and --timeout.
+	store       *share.Store
+	size        int64
+	idleTimeout time.Duration
+	fixedPath   string // instead of random ones, for --token-only
+	countdown   *countdown
+	timedOut    <-chan struct{}
+
+	urls []string    // as last announced
+	keys <-chan byte // from hotkeys
+	move func()      // to a new path, for the r hotkey
+}
+
+func newServer(opts *serveOptions) *server {
+	if opts.iface != "" && opts.ip != "" {
+		log.Fatal("--iface and --ip are mutually exclusive")
+	}
+	if opts.allIfaces && (opts.publicURL != "" || opts.mdns || opts.iface != "" || opts.ip != "") {
+		log.Fatal("--all-ifaces cannot be combined with --public-url, --mdns, --iface or --ip")
+	}
+	if opts.wan && (opts.publicURL != "" || opts.mdns || opts.allIfaces) {
+		log.Fatal("--wan cannot be combined with --public-url, --mdns or --all-ifaces")
+	}
+	if opts.tailscale && (opts.publicURL != "" || opts.mdns || opts.allIfaces || opts.wan || opts.iface != "" || opts.ip != "") {
+		log.Fatal("--tailscale cannot be combined with other address flags")
+	}
+	if opts.http3 && !opts.tls {
+		log.Fatal("--http3 needs --tls")
+	}
+	if opts.http3 && (opts.publicURL != "" || opts.listen != "" || opts.wan) {
+		log.Fatal("--http3 cannot be combined with --public-url, --listen or --wan")
+	}
+	if (len(opts.allow) > 0 || opts.lanOnly) && opts.listen != "" {
+		log.Fatal("--allow and --lan-only cannot be combined with --listen, where every peer is the proxy")
+	}
+	if opts.lanOnly && (opts.wan || opts.tailscale || opts.publicURL != "") {
+		log.Fatal("--lan-only cannot be combined with --wan, --tailscale or --public-url")
+	}
+	if opts.listen != "" {
+		opts.listenUnix()
+	}
+	if opts.tui {
+		opts.startTUI()
+	}
+	srv, err := share.New(opts.options()...)
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "listen" {
+		log.Print(err)
+		os.Exit(exitListen)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &server{Server: srv, opts: opts}
+}
+
+// listenUnix opens the --listen socket. The proxy in front decides the
+// address, so there is nothing to pick or advertise but --public-url.
+func (o *serveOptions) listenUnix() {
+	path, ok := strings.CutPrefix(o.listen, "unix:")
+	switch {
+	case !ok || path == "":
+		log.Fatal("--listen takes unix:path")
+	case o.publicURL == "":
+		log.Fatal("--listen needs --public-url, the address the proxy serves the socket under")
+	case o.port != 0 || o.mdns || o.allIfaces || o.wan || o.tailscale:
+		log.Fatal("--listen cannot be combined with --port, --mdns, --all-ifaces, --wan or --tailscale")
+	case o.listener != nil:
+		log.Fatal("--listen cannot be combined with a socket from systemd")
+	}
+	l, err := listenUnix(path)
+	if err == nil && runtime.GOOS != "windows" {
+		// Others have to be able to connect, as they could to a TCP port;
+		// the path in the URL still guards the note.
+		if err = os.Chmod(path, 0o666); err != nil {
+			l.Close()
+		}
+	}
+	if err != nil {
+		log.Print(err)
+		os.Exit(exitListen)
+	}
+	o.listener = l
+}
+
+// listenUnix listens on the unix socket path, replacing a stale socket
+// file left by a process that is gone.
+func listenUnix(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another process", path)
+	}
+	os.Remove(path)
+	return net.Listen("unix", path)
+}
+
+// startTUI sets up the --tui screen, which takes over once run starts.
+func (o *serveOptions) startTUI() {
+	if o.screen != nil {
+		return
+	}
+	if o.json || o.verbosity < 0 || o.qr.noQR || o.qr.qrOnly {
+		log.Fatal("--tui cannot be combined with --json, --quiet, --no-qr or --qr-only")
+	}
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		log.Fatal("--tui needs a terminal")
+	}
+	o.screen = newTUI(os.Stdout)
+}
+
+// run serves the handler for a random path, prints a URL and QR code per
+// endpoint with fragment appended, and blocks until done, a signal or the
+// TTL. It returns the exit code for how it ended.
+func (s *server) run(label, fragment string, handler func(path string) http.Handler, done <-chan struct{}) int {
+	screen := s.opts.screen
+	if screen != nil {
+		h := handler
+		handler = func(path string) http.Handler { return screen.counted(h(path)) }
+	}
+	routes := &switchMux{fixed: s.fixedPath}
+	path := routes.move(handler)
+	s.start(routes)
+	s.announce(label, path, fragment)
+	var restore func()
+	s.keys, restore = s.hotkeys()
+	defer restore()
+	s.move = func() {
+		if routes.fixed != "" && s.opts.linkTTL > 0 {
+			// Still a link that works for the full --link-ttl.
+			s.opts.qr.copied = false
+			s.announce(label, routes.fixed, fragment)
+			return
+		}
+		if routes.fixed != "" {
+			s.opts.say("The note has a fixed path and cannot be moved.")
+			return
+		}
+		path := routes.move(handler)
+		s.opts.say("Moved the note, the old URL no longer works.")
+		s.opts.qr.copied = false
+		s.announce(label, path, fragment)
+	}
+	if s.idleTimeout > 0 {
+		var status *os.File
+		if w := s.opts.qr.human(); term.IsTerminal(int(w.Fd())) && !s.opts.json && s.opts.verbosity >= 0 && screen == nil {
+			status = w
+		}
+		s.countdown = startCountdown(s.store, s.idleTimeout, status)
+		defer s.countdown.Stop()
+		s.timedOut = s.countdown.expired
+	}
+	if screen != nil {
+		screen.run(s)
+		defer screen.Stop()
+	}
+	code := s.wait(done)
+	s.stop()
+	return code
+}
+
+// serveNote serves n for downloads fetches, or until stopped with 0, and
+// exits with the code for how that ended. It is for the commands that make
+// their note themselves; browsers get the pin check with --tls and a click
+// through otherwise, as with send.
+func serveNote(opts *serveOptions, n *share.Note, downloads int, label string) {
+	if downloads == 0 {
+		opts.hostCheck = true
+	}
+	store := share.NewStore(n, share.WithMaxDownloads(downloads))
+	done := make(chan struct{})
+	srv := newServer(opts)
+	srv.store, srv.size = store, int64(len(n.Content))
+	var fragment string
+	if srv.Cert() != nil {
+		fragment = share.SPKIPin(srv.Cert())
+	}
+	handler := func(path string) http.Handler {
+		var handler http.Handler = share.NoteHandler(store, done)
+		if srv.Cert() != nil {
+			handler = share.PinnedHandler(store, srv.Cert(), handler, done)
+		} else {
+			handler = share.ConfirmHandler(handler)
+		}
+		return share.BotFilter(nil, handler)
+	}
+	code := srv.run(label, fragment, handler, done)
+	store.Burn()
+	switch {
+	case store.Fetches() > 0:
+		code = exitFetched
+	case code == exitFetched:
+		code = exitNotFetched
+	}
+	os.Exit(code)
+}
+
+func (s *server) start(handler http.Handler) {
+	go func() {
+		if err := s.Serve(handler); err != nil {
+			fatalf("server failed: %v", err)
+		}
+	}()
+}
+
+func (s *server) announce(label, path, fragment string) {
+	path = s.SignPath(path)
+	names, urls := s.URLs(path)
+	s.urls = urls
+	for i := range urls {
+		if fragment != "" {
+			urls[i] += "#" + fragment
+		}
+		name := ""
+		if len(urls) > 1 {
+			name = names[i]
+		}
+		switch {
+		case s.opts.screen != nil:
+			s.opts.qr.save(urls[i], name)
+			s.opts.qr.copy(urls[i])
+		case name != "":
+			s.opts.qr.show(fmt.Sprintf("%s (%s)", label, name), urls[i], name)
+		default:
+			s.opts.qr.show(label, urls[i], "")
+		}
+	}
+	if s.opts.screen != nil {
+		s.opts.screen.announce(label, names, urls, s.opts.qr)
+	}
+	if cert := s.Cert(); cert != nil {
+		s.opts.detail("cert_sha256", "Certificate SHA-256:", share.Fingerprint(cert.Certificate[0]))
+	}
+	if s.opts.json {
+		s.opts.printJSON(names, urls, path)
+	} else if s.opts.ttl > 0 && !s.opts.qr.noQR && !s.opts.qr.qrOnly && s.opts.screen == nil {
+		fmt.Fprintln(s.opts.qr.human(), "Expires in:", s.opts.ttl)
+	}
+	if s.opts.linkTTL > 0 && !s.opts.json && !s.opts.qr.noQR && !s.opts.qr.qrOnly && s.opts.screen == nil {
+		fmt.Fprintln(s.opts.qr.human(), "Link expires in:", s.opts.linkTTL)
+	}
+}
+
+// detail prints a line about the note next to the QR code, or keeps it
+// for --json. --no-qr and --qr-only leave out all but the PIN and code
+// phrase, which the receiver needs.
+func (o *serveOptions) detail(key, label, value string) {
+	if o.screen != nil {
+		o.screen.detail(key, label+" "+value)
+		return
+	}
+	if !o.json {
+		if !o.qr.noQR && !o.qr.qrOnly || key == "pin" || key == "code" || key == "token" || key == "cert_password" {
+			fmt.Fprintln(o.qr.human(), label, value)
+		}
+		return
+	}
+	if o.details == nil {
+		o.details = map[string]any{}
+	}
+	o.details[key] = value
+}
+
+// printJSON prints the --json object for the URLs of path and the
+// details.
+func (o *serveOptions) printJSON(names, urls []string, path string) {
+	out := map[string]any{"url": urls[0], "path": path}
+	for k, v := range o.details {
+		out[k] = v
+	}
+	if u, err := url.Parse(urls[0]); err == nil {
+		if port, err := strconv.Atoi(u.Port()); err == nil {
+			out["port"] = port
+		}
+	}
+	if len(urls) > 1 {
+		all := make([]map[string]string, len(urls))
+		for i := range urls {
+			all[i] = map[string]string{"name": names[i], "url": urls[i]}
+		}
+		out["urls"] = all
+	}
+	if o.ttl > 0 {
+		out["expires_at"] = time.Now().Add(o.ttl).UTC().Format(time.RFC3339)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(data))
+}
+
+// wait blocks until done, a signal, the TTL or the q hotkey and returns
+// the exit code for which it was.
+func (s *server) wait(done <-chan struct{}) int {
+	stop := make(chan os.Signal, 1)
+	notifyStop(stop)
+
+	for {
+		select {
+		case <-done:
+			s.opts.log.Debug("shutting down", "reason", "fetched")
+			return exitFetched
+		case sig := <-stop:
+			s.opts.log.Debug("shutting down", "reason", sig.String())
+			return exitInterrupted
+		case <-s.Expired():
+			return exitNotFetched
+		case <-s.timedOut:
+			if s.opts.verbosity >= 0 {
+				log.Printf("nobody fetched the note for %s, shutting down", s.idleTimeout)
+			}
+			return exitNotFetched
+		case key := <-s.keys:
+			if s.hotkey(key) {
+				s.opts.log.Debug("shutting down", "reason", "quit")
+				return exitInterrupted
+			}
+		}
+	}
+}
+
+func (s *server) stop() {
+	if err := s.Shutdown(); err != nil {
+		log.Printf("server shutdown failed: %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kevinkokinda/qreph/pkg/qr"
+)
+
+// Split mode cuts a secret into Shamir shares over GF(256): every byte is
+// the constant term of a random polynomial of degree threshold-1, and share
+// i holds the polynomials evaluated at i. Any threshold shares give the
+// secret back by interpolation, fewer tell nothing about it. The split
+// secret ends in a truncated SHA-256 of itself, so combine can tell a good
+// result from shares that do not belong together.
+
+const sharePrefix = "QS1:"
+
+const shareCheckSize = 4
+
+var gfExp, gfLog = gfTables()
+
+// gfTables returns the powers of the generator 3 in GF(256) with the AES
+// polynomial, twice over so products need no modulo, and their logarithms.
+func gfTables() (exp [510]byte, logs [256]byte) {
+	x := byte(1)
+	for i := range 255 {
+		exp[i], exp[i+255] = x, x
+		logs[x] = byte(i)
+		// x *= 3
+		double := x << 1
+		if x&0x80 != 0 {
+			double ^= 0x1b
+		}
+		x ^= double
+	}
+	return exp, logs
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+type secretShare struct {
+	set       string // tells the shares of one split apart from others
+	threshold int
+	index     int // 1 to 255, the x coordinate
+	data      []byte
+}
+
+func (s secretShare) String() string {
+	return fmt.Sprintf("%s%s:%d:%d:%s", sharePrefix, s.set, s.threshold, s.index, base64.RawURLEncoding.EncodeToString(s.data))
+}
+
+func parseShare(s string) (secretShare, error) {
+	var sh secretShare
+	rest, ok := strings.CutPrefix(strings.TrimSpace(s), sharePrefix)
+	parts := strings.Split(rest, ":")
+	if !ok || len(parts) != 4 {
+		return sh, errors.New("not a qreph share")
+	}
+	var err error
+	sh.set = parts[0]
+	if sh.threshold, err = strconv.Atoi(parts[1]); err != nil || sh.threshold < 2 {
+		return sh, errors.New("bad threshold")
+	}
+	if sh.index, err = strconv.Atoi(parts[2]); err != nil || sh.index < 1 || sh.index > 255 {
+		return sh, errors.New("bad share index")
+	}
+	if sh.data, err = base64.RawURLEncoding.DecodeString(parts[3]); err != nil {
+		return sh, err
+	}
+	return sh, nil
+}
+
+// splitSecret cuts secret into n shares, any threshold of which recover it.
+func splitSecret(secret []byte, threshold, n int) []secretShare {
+	sum := sha256.Sum256(secret)
+	data := append(bytes.Clone(secret), sum[:shareCheckSize]...)
+	defer clear(data)
+	setID := make([]byte, 4)
+	rand.Read(setID)
+	shares := make([]secretShare, n)
+	for i := range shares {
+		shares[i] = secretShare{set: hex.EncodeToString(setID), threshold: threshold, index: i + 1, data: make([]byte, len(data))}
+	}
+	coef := make([]byte, threshold)
+	defer clear(coef)
+	for j, b := range data {
+		coef[0] = b
+		rand.Read(coef[1:])
+		for _, sh := range shares {
+			// Horner's rule at x = index.
+			x, y := byte(sh.index), byte(0)
+			for k := threshold - 1; k >= 0; k-- {
+				y = gfMul(y, x) ^ coef[k]
+			}
+			sh.data[j] = y
+		}
+	}
+	return shares
+}
+
+// combineShares interpolates the shares at 0 and checks the result.
+func combineShares(shares []secretShare) ([]byte, error) {
+	data := make([]byte, len(shares[0].data))
+	for i, si := range shares {
+		// The Lagrange basis polynomial of share i at 0; subtraction is XOR.
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(byte(sj.index), byte(sj.index^si.index)))
+			}
+		}
+		for k, y := range si.data {
+			data[k] ^= gfMul(y, basis)
+		}
+	}
+	if len(data) < shareCheckSize {
+		return nil, errors.New("shares are too short")
+	}
+	secret, check := data[:len(data)-shareCheckSize], data[len(data)-shareCheckSize:]
+	if sum := sha256.Sum256(secret); !bytes.Equal(sum[:shareCheckSize], check) {
+		clear(data)
+		return nil, errors.New("the shares do not fit together")
+	}
+	return secret, nil
+}
+
+// split shows each share of the secret as a QR code of its own.
+func split(args []string) {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	threshold := fs.Int("threshold", 2, "need `n` shares to recover the secret")
+	shares := fs.Int("shares", 3, "make `n` shares")
+	filePath := fs.String("f", "", "split the file at `path`")
+	opts := addQRFlags(fs)
+	opts.addPaperFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph split [--threshold n] [--shares n] [--qr-out file] [--paper file] [-f path | text]")
+		fmt.Fprintln(fs.Output(), "Splits a secret into shares shown as QR codes; qreph combine recovers it from any --threshold of them.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if *threshold < 2 || *shares < *threshold || *shares > 255 {
+		log.Fatal("want 2 <= --threshold <= --shares <= 255")
+	}
+	var secret []byte
+	var err error
+	switch {
+	case *filePath != "" && fs.NArg() > 0:
+		log.Fatal("-f cannot be combined with text arguments")
+	case *filePath != "":
+		secret, err = os.ReadFile(*filePath)
+	case fs.NArg() > 0:
+		secret = []byte(strings.Join(fs.Args(), " "))
+	default:
+		secret, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		log.Fatalf("failed to read the secret: %v", err)
+	}
+	if len(secret) == 0 {
+		log.Fatal("no content provided")
+	}
+
+	parts := splitSecret(secret, *threshold, *shares)
+	clear(secret)
+	human, data := opts.streams()
+	var pages []qr.Page
+	for _, sh := range parts {
+		text := sh.String()
+		if err := qr.Fits(text, opts.Level); err != nil {
+			log.Fatalf("share does not fit in a QR code: %v", err)
+		}
+		switch {
+		case opts.noQR:
+			fmt.Fprintln(data, text)
+		case !opts.qrOnly:
+			fmt.Fprintf(human, "Share %d of %d, any %d recover the secret:\n", sh.index, *shares, *threshold)
+		}
+		opts.draw(text, "share"+strconv.Itoa(sh.index))
+		pages = append(pages, paperPage(fmt.Sprintf("Share %d of %d, any %d recover the secret with qreph combine", sh.index, *shares, *threshold), text))
+	}
+	// A page per share, to hand out separately.
+	opts.writePaper(pages...)
+}
+
+// combine reads shares, one per line as qreph decode prints them, and
+// writes the secret to stdout once it has enough.
+func combine(args []string) {
+	fs := flag.NewFlagSet("combine", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph combine [file...]")
+		fmt.Fprintln(fs.Output(), "Recovers a secret from qreph split shares read one per line, e.g. qreph decode share*.png | qreph combine.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	var lines []*bufio.Scanner
+	if fs.NArg() == 0 {
+		lines = append(lines, bufio.NewScanner(os.Stdin))
+	}
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("failed to open shares: %v", err)
+		}
+		defer f.Close()
+		lines = append(lines, bufio.NewScanner(f))
+	}
+
+	var have []secretShare
+	seen := make(map[int]bool)
+	for _, sc := range lines {
+		for sc.Scan() {
+			sh, err := parseShare(sc.Text())
+			if err != nil || seen[sh.index] {
+				continue
+			}
+			if len(have) > 0 && (sh.set != have[0].set || sh.threshold != have[0].threshold || len(sh.data) != len(have[0].data)) {
+				log.Fatal("share belongs to a different secret")
+			}
+			seen[sh.index] = true
+			have = append(have, sh)
+			if len(have) < sh.threshold {
+				continue
+			}
+			secret, err := combineShares(have)
+			if err != nil {
+				log.Fatalf("failed to combine shares: %v", err)
+			}
+			os.Stdout.Write(secret)
+			clear(secret)
+			return
+		}
+	}
+	if len(have) == 0 {
+		log.Fatal("no qreph shares found")
+	}
+	log.Fatalf("ran out of shares with %d of the %d needed", len(have), have[0].threshold)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"math/bits"
+	"testing"
+)
+
+func TestGFDivUndoesMul(t *testing.T) {
+	for a := range 256 {
+		for b := 1; b < 256; b++ {
+			if got := gfDiv(gfMul(byte(a), byte(b)), byte(b)); got != byte(a) {
+				t.Fatalf("gfDiv(gfMul(%d, %d), %d) = %d", a, b, b, got)
+			}
+		}
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+	tests := []struct {
+		name         string
+		secret       string
+		threshold, n int
+	}{
+		{"two of two", "hello", 2, 2},
+		{"two of three", "hello", 2, 3},
+		{"three of five", "correct horse battery staple", 3, 5},
+		{"five of five", "x", 5, 5},
+		{"four of eight", string(bytes.Repeat([]byte{0, 0xff}, 300)), 4, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares := splitSecret([]byte(tt.secret), tt.threshold, tt.n)
+			if len(shares) != tt.n {
+				t.Fatalf("got %d shares, want %d", len(shares), tt.n)
+			}
+			// Every subset of threshold shares, in index order.
+			for mask := uint(0); mask < 1<<tt.n; mask++ {
+				if bits.OnesCount(mask) != tt.threshold {
+					continue
+				}
+				var some []secretShare
+				for i, sh := range shares {
+					if mask&(1<<i) != 0 {
+						some = append(some, sh)
+					}
+				}
+				got, err := combineShares(some)
+				if err != nil {
+					t.Fatalf("shares %b: %v", mask, err)
+				}
+				if string(got) != tt.secret {
+					t.Fatalf("shares %b gave %q", mask, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitCombineMaxShares(t *testing.T) {
+	shares := splitSecret([]byte("hello"), 3, 255)
+	got, err := combineShares([]secretShare{shares[254], shares[0], shares[127]})
+	if err != nil || string(got) != "hello" {
+		t.Fatalf("combineShares = %q, %v", got, err)
+	}
+}
+
+func TestCombineRejects(t *testing.T) {
+	a := splitSecret([]byte("hello"), 3, 5)
+	b := splitSecret([]byte("hello"), 3, 5)
+	tests := []struct {
+		name   string
+		shares []secretShare
+	}{
+		{"too few", a[:2]},
+		{"other split", []secretShare{a[0], a[1], b[2]}},
+		{"changed byte", []secretShare{a[0], a[1], {set: a[2].set, threshold: 3, index: 3, data: append([]byte{a[2].data[0] ^ 1}, a[2].data[1:]...)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := combineShares(tt.shares); err == nil {
+				t.Fatalf("combineShares = %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestShareString(t *testing.T) {
+	for _, sh := range splitSecret([]byte("hello"), 2, 3) {
+		got, err := parseShare(sh.String())
+		if err != nil {
+			t.Fatalf("parseShare(%q): %v", sh, err)
+		}
+		if got.set != sh.set || got.threshold != sh.threshold || got.index != sh.index || !bytes.Equal(got.data, sh.data) {
+			t.Fatalf("parseShare(%q) = %+v", sh, got)
+		}
+	}
+	for _, s := range []string{"", "QS1:", "QS1:ab:1:1:AA", "QS1:ab:2:0:AA", "QS1:ab:2:256:AA", "QS1:ab:2:1:!", "QS2:ab:2:1:AA"} {
+		if _, err := parseShare(s); err == nil {
+			t.Errorf("parseShare(%q) succeeded", s)
+		}
+	}
+}
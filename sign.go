@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// Signatures are plain Ed25519 over the note's content, so keys made with
+// ssh-keygen -t ed25519 or openssl genpkey -algorithm ed25519 both work.
+
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		var passphrase []byte
+		passphrase, err = readPassword("Passphrase for " + path)
+		if err != nil {
+			return nil, err
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+		clear(passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return k, nil
+	case *ed25519.PrivateKey:
+		return *k, nil
+	}
+	return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+}
+
+// loadVerifyKey reads a public key in authorized_keys or PEM form.
+func loadVerifyKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var key any
+	if block, _ := pem.Decode(data); block != nil {
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	} else {
+		var pub ssh.PublicKey
+		pub, _, _, _, err = ssh.ParseAuthorizedKey(data)
+		if err == nil {
+			key = pub.(ssh.CryptoPublicKey).CryptoPublicKey()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	return pub, nil
+}
+
+func keyFingerprint(pub ed25519.PublicKey) string {
+	k, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(k)
+}
+
+func verify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyFile := fs.String("key", "", "check against the sender's Ed25519 public key in `file`")
+	sig := fs.String("sig", "", "base64 `signature` of a local file, as printed by the sender")
+	outDir := fs.String("o", ".", "write files to `dir`, or - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph verify --key file [-o dir] <url> | qreph verify --key file --sig signature <file>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *keyFile == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	pub, err := loadVerifyKey(*keyFile)
+	if err != nil {
+		log.Fatalf("failed to read public key: %v", err)
+	}
+
+	target := fs.Arg(0)
+	fetched := strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+	var p *share.Payload
+	if fetched {
+		var header http.Header
+		p, header, err = share.Fetch(target)
+		if err != nil {
+			log.Fatalf("failed to fetch note: %v", err)
+		}
+		if *sig == "" {
+			*sig = header.Get(share.SignatureHeader)
+		}
+	} else {
+		data, err := os.ReadFile(target)
+		if err != nil {
+			log.Fatalf("failed to read file: %v", err)
+		}
+		p = &share.Payload{Data: data}
+	}
+	if *sig == "" {
+		log.Fatal("the note is not signed")
+	}
+	raw, err := base64.StdEncoding.DecodeString(*sig)
+	if err != nil || !ed25519.Verify(pub, p.Data, raw) {
+		log.Fatal("BAD signature, the note was not sent by the owner of this key")
+	}
+	fmt.Fprintln(os.Stderr, "Good signature from", keyFingerprint(pub))
+	if fetched {
+		savePayload(p, *outDir)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+// defaultPublicKey returns the first of the usual public key files in
+// ~/.ssh that exists.
+func defaultPublicKey() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range []string{"id_ed25519.pub", "id_ecdsa.pub", "id_rsa.pub"} {
+		path := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("no public key in ~/.ssh, name one")
+}
+
+// sshKey serves an SSH public key, with a page that has the command to add
+// it to authorized_keys for browsers and the bare key for curl.
+func sshKey(args []string) {
+	fs := flag.NewFlagSet("ssh-key", flag.ExitOnError)
+	count := fs.Int("count", 1, "allow the key to be fetched `n` times")
+	keep := fs.Bool("keep", false, "serve the key until interrupted or --ttl expires instead of once")
+	opts := addServeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph ssh-key [--count n | --keep] [--tls] [--ttl duration] [key.pub]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if opts.tui {
+		log.Fatal("qreph ssh-key has no --tui")
+	}
+	if *count < 1 {
+		log.Fatal("--count must be at least 1")
+	}
+
+	var path string
+	var err error
+	switch fs.NArg() {
+	case 0:
+		if path, err = defaultPublicKey(); err != nil {
+			log.Fatal(err)
+		}
+	case 1:
+		path = fs.Arg(0)
+	default:
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("failed to read public key: %v", err)
+	}
+	if bytes.Contains(data, []byte("PRIVATE KEY")) {
+		log.Fatalf("%s is a private key, use the .pub file next to it", path)
+	}
+	key, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		log.Fatalf("failed to parse public key %s: %v", path, err)
+	}
+	// Just the first key, without options or other lines of the file.
+	line := bytes.TrimSpace(ssh.MarshalAuthorizedKey(key))
+	if comment != "" {
+		line = append(append(line, ' '), comment...)
+	}
+	n := &share.Note{Content: append(line, '\n'), ContentType: "text/plain; charset=utf-8", View: share.ViewSSHKey}
+	sum := sha256.Sum256(n.Content)
+	n.Sum = hex.EncodeToString(sum[:])
+	opts.detail("fingerprint", "Key fingerprint:", ssh.FingerprintSHA256(key))
+
+	downloads := *count
+	if *keep {
+		downloads = 0
+	}
+	serveNote(opts, n, downloads, "Serving SSH key at:")
+}
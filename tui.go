@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// tui takes over the terminal for --tui: the QR code with the URL, details
+// and transfer next to it, the log below and the keys at the bottom. Until
+// it runs, what is logged to it goes straight to stderr.
+type tui struct {
+	out *os.File
+	srv *server
+
+	mu      sync.Mutex
+	running bool
+	start   time.Time
+	label   string
+	names   []string
+	urls    []string
+	qr      []string
+	details []detailLine
+	logs    []string
+
+	sent    atomic.Int64 // bytes of all responses
+	sending atomic.Int64 // bytes of the responses still going out
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+type detailLine struct{ key, text string }
+
+// tuiLogLines is how much of the log the TUI keeps.
+const tuiLogLines = 200
+
+func newTUI(out *os.File) *tui {
+	return &tui{out: out, start: time.Now()}
+}
+
+// Write adds log lines.
+func (t *tui) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.running {
+		return os.Stderr.Write(p)
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.logs = append(t.logs, line)
+	}
+	if len(t.logs) > tuiLogLines {
+		t.logs = t.logs[len(t.logs)-tuiLogLines:]
+	}
+	return len(p), nil
+}
+
+func (t *tui) say(msg string) {
+	fmt.Fprintln(t, msg)
+}
+
+func (t *tui) announce(label string, names, urls []string, o *qrOptions) {
+	lines, err := o.Lines(urls[0])
+	if err != nil {
+		lines = nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.label, t.names, t.urls, t.qr = label, names, urls, lines
+}
+
+// detail shows text among the details, replacing the one with the same key.
+func (t *tui) detail(key, text string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.details {
+		if t.details[i].key == key {
+			t.details[i].text = text
+			return
+		}
+	}
+	t.details = append(t.details, detailLine{key, text})
+}
+
+// counted counts the bytes next sends for the progress display.
+func (t *tui) counted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &countingWriter{ResponseWriter: w, t: t}
+		next.ServeHTTP(cw, r)
+		t.sending.Add(-cw.n)
+	})
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	t *tui
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	w.t.sent.Add(int64(n))
+	w.t.sending.Add(int64(n))
+	return n, err
+}
+
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// run switches to the alternate screen and redraws it until Stop.
+func (t *tui) run(s *server) {
+	t.mu.Lock()
+	t.srv, t.running = s, true
+	t.mu.Unlock()
+	t.stop, t.stopped = make(chan struct{}), make(chan struct{})
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?25l")
+	go func() {
+		defer close(t.stopped)
+		tick := time.NewTicker(250 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			fmt.Fprint(t.out, t.frame())
+			select {
+			case <-tick.C:
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop gives the terminal back and prints the log on it, which would
+// otherwise vanish with the screen.
+func (t *tui) Stop() {
+	close(t.stop)
+	<-t.stopped
+	fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running = false
+	for _, line := range t.logs {
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+// frame draws the whole screen.
+func (t *tui) frame() string {
+	width, height, err := term.GetSize(int(t.out.Fd()))
+	if err != nil || width == 0 || height == 0 {
+		width, height = 80, 24
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	qrWidth := 0
+	if len(t.qr) > 0 {
+		qrWidth = utf8.RuneCountInString(t.qr[0])
+	}
+	side := qrWidth > 0 && width >= qrWidth+2+40
+	infoWidth := width
+	if side {
+		infoWidth = width - qrWidth - 2
+	}
+	info := t.info(infoWidth)
+
+	var lines []string
+	title := " qreph  " + t.label
+	elapsed := formatClock(time.Since(t.start).Round(time.Second)) + " "
+	lines = append(lines, "\x1b[7m"+pad(title, width-len(elapsed))+elapsed+"\x1b[0m")
+	if side {
+		for i := 0; i < max(len(t.qr), len(info)); i++ {
+			left := strings.Repeat(" ", qrWidth)
+			if i < len(t.qr) {
+				left = t.qr[i]
+			}
+			right := ""
+			if i < len(info) {
+				right = info[i]
+			}
+			lines = append(lines, left+"  "+right)
+		}
+	} else {
+		lines = append(append(lines, t.qr...), info...)
+	}
+
+	footer := ""
+	if t.srv != nil && t.srv.keys != nil {
+		footer = "\x1b[7m" + pad(" r new URL  c copy URL  o open in browser  q quit", width) + "\x1b[0m"
+	}
+	rows := height - len(lines) - 1
+	if footer != "" {
+		rows--
+	}
+	if rows > 0 {
+		lines = append(lines, "\x1b[2m"+strings.Repeat("─", width)+"\x1b[0m")
+		logs := t.logs[max(0, len(t.logs)-rows):]
+		for _, l := range logs {
+			lines = append(lines, truncate(l, width))
+		}
+		for i := len(logs); i < rows; i++ {
+			lines = append(lines, "")
+		}
+	}
+	if footer != "" {
+		lines = append(lines, footer)
+	}
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return "\x1b[H" + strings.Join(lines, "\x1b[K\n") + "\x1b[K\x1b[J"
+}
+
+// info returns the lines next to the QR code, at most width wide.
+func (t *tui) info(width int) []string {
+	var info []string
+	add := func(s string) { info = append(info, truncate(s, width)) }
+	for i, u := range t.urls {
+		if len(t.urls) > 1 {
+			add(t.names[i] + ":")
+		}
+		for len(u) > width {
+			info, u = append(info, u[:width]), u[width:]
+		}
+		info = append(info, u)
+	}
+	add("")
+	for _, d := range t.details {
+		add(d.text)
+	}
+	s := t.srv
+	if s == nil {
+		return info
+	}
+	add("")
+	if s.store != nil {
+		add("Fetches: " + fmt.Sprint(s.store.Fetches()))
+	}
+	if sending := t.sending.Load(); sending > 0 && s.size > 0 {
+		done := min(sending, s.size)
+		bar := max(10, min(30, width-20))
+		filled := int(int64(bar) * done / s.size)
+		add(fmt.Sprintf("Sending: [%s%s] %d%%", strings.Repeat("#", filled), strings.Repeat("-", bar-filled), 100*done/s.size))
+	} else {
+		add("Sent: " + formatBytes(t.sent.Load()))
+	}
+	if ttl := s.opts.ttl; ttl > 0 {
+		add("Expires in: " + formatClock(max(0, time.Until(t.start.Add(ttl)).Round(time.Second))))
+	}
+	if s.countdown != nil {
+		add(s.countdown.Line())
+	}
+	return info
+}
+
+// pad fills s with spaces to width, or cuts it there.
+func pad(s string, width int) string {
+	s = truncate(s, width)
+	return s + strings.Repeat(" ", max(0, width-utf8.RuneCountInString(s)))
+}
+
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	r := []rune(s)
+	if width < 1 {
+		return ""
+	}
+	return string(r[:width-1]) + "…"
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
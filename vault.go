@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// readVault reads ref, an API path and an optional field like
+// secret/data/foo#password, from HashiCorp Vault. It finds Vault the way
+// the vault CLI does: $VAULT_ADDR, $VAULT_CACERT, $VAULT_NAMESPACE, and
+// $VAULT_TOKEN or the token vault login left in ~/.vault-token.
+func readVault(ref string) ([]byte, error) {
+	path, field, _ := strings.Cut(ref, "#")
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		addr = "https://127.0.0.1:8200"
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			data, _ := os.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(data))
+		}
+	}
+	if token == "" {
+		return nil, errors.New("set VAULT_TOKEN or run vault login")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if caFile := os.Getenv("VAULT_CACERT"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Data   map[string]json.RawMessage `json:"data"`
+		Errors []string                   `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(body.Errors) > 0 {
+			return nil, fmt.Errorf("%s: %s", resp.Status, strings.Join(body.Errors, "; "))
+		}
+		return nil, errors.New(resp.Status)
+	}
+
+	data := body.Data
+	// Version 2 of the KV engine puts the secret under data, next to its
+	// metadata.
+	if inner, ok := data["data"]; ok && data["metadata"] != nil {
+		data = nil
+		if err := json.Unmarshal(inner, &data); err != nil {
+			return nil, err
+		}
+	}
+	fields := slices.Sorted(maps.Keys(data))
+	switch {
+	case field == "" && len(fields) == 1:
+		field = fields[0]
+	case field == "":
+		return nil, fmt.Errorf("name one of the fields after #: %s", strings.Join(fields, ", "))
+	case data[field] == nil:
+		return nil, fmt.Errorf("no field %q, only %s", field, strings.Join(fields, ", "))
+	}
+	var s string
+	if err := json.Unmarshal(data[field], &s); err == nil {
+		return []byte(s), nil
+	}
+	// Anything but a string goes out as JSON.
+	return data[field], nil
+}
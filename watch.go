@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/kevinkokinda/qreph/pkg/share"
+)
+
+const clipboardPollInterval = 500 * time.Millisecond
+
+// watchClip keeps one one-time URL alive for whatever was copied last,
+// replacing it and redrawing the QR code whenever the clipboard changes.
+func watchClip(args []string) {
+	fs := flag.NewFlagSet("watch-clip", flag.ExitOnError)
+	opts := addServeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: qreph watch-clip [--tls] [--ttl duration]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	opts.hardenProcess()
+	if opts.tui {
+		log.Fatal("qreph watch-clip has no --tui")
+	}
+	// Copying the URL would feed it straight back into the watcher.
+	opts.qr.noCopy = true
+
+	srv := newServer(opts)
+	var mu sync.Mutex
+	var currentPath string
+	var current http.Handler
+	srv.start(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		path, handler := currentPath, current
+		mu.Unlock()
+		if handler == nil || r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+
+	go func() {
+		var last []byte
+		var store *share.Store
+		var replaced chan struct{}
+		var failing bool
+		for ; ; time.Sleep(clipboardPollInterval) {
+			content, err := readClipboard()
+			if err != nil {
+				// Once per run of failures, the clipboard often comes back
+				// (a locked screen, a restarted clipboard manager).
+				if !failing {
+					log.Printf("failed to read clipboard, still watching: %v", err)
+				}
+				failing = true
+				continue
+			}
+			failing = false
+			if len(bytes.TrimSpace(content)) == 0 || bytes.Equal(content, last) {
+				continue
+			}
+			last = content
+
+			path, err := share.RandomPath()
+			if err != nil {
+				log.Fatalf("failed to generate random bytes: %v", err)
+			}
+			if store != nil {
+				store.Burn()
+				close(replaced)
+			}
+			replaced = make(chan struct{})
+			store = share.NewStore(&share.Note{Content: content, ContentType: "text/plain; charset=utf-8"})
+			done := make(chan struct{})
+			var handler http.Handler = share.NoteHandler(store, done)
+			var fragment string
+			if srv.Cert() != nil {
+				handler = share.PinnedHandler(store, srv.Cert(), handler, done)
+				fragment = share.SPKIPin(srv.Cert())
+			}
+			mu.Lock()
+			currentPath, current = path, share.BotFilter(nil, handler)
+			mu.Unlock()
+
+			if w := opts.qr.human(); term.IsTerminal(int(w.Fd())) {
+				fmt.Fprint(w, "\x1b[H\x1b[2J")
+			}
+			srv.announce("Serving clipboard at:", path, fragment)
+			go func(replaced chan struct{}) {
+				select {
+				case <-done:
+					fmt.Fprintln(opts.qr.human(), "Fetched, copy something else to share it.")
+				case <-replaced:
+				}
+			}(replaced)
+		}
+	}()
+
+	srv.wait(nil)
+	srv.stop()
+}